@@ -0,0 +1,304 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+// Binary catbus-lifx-genconfig writes a config for the Lifx bulbs on the local network, or adds newly found bulbs to an existing config.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.eth.moe/catbus-lifx/config"
+	"go.eth.moe/catbus-lifx/homie"
+	"go.eth.moe/catbus-lifx/lifx"
+)
+
+var (
+	configPath = flag.String("config-path", "", "existing config to add new bulbs to, in JSON, YAML, or TOML, whose bulbs and groups are kept as they are")
+	inPlace    = flag.Bool("in-place", false, "write the config back to --config-path, rather than to stdout, losing its comments and ordering")
+	format     = flag.String("format", "", "json, yaml, or toml, defaulting to the format of --config-path, or json")
+
+	broker        = flag.String("broker", "tcp://localhost:1883", "MQTT broker URI, unless the config already has one")
+	topicTemplate = flag.String("topic-template", "lifx/{name}/{field}", "template for new bulbs' topics, unless the config already has a topicTemplate")
+	addGroups     = flag.Bool("groups", true, "also configure each group in the Lifx app as a group of its bulbs")
+
+	timeout = flag.Duration("timeout", 10*time.Second, "how long to wait for bulbs to respond")
+)
+
+// discovered is a bulb found on the network.
+type discovered struct {
+	label string
+	info  lifx.Info
+}
+
+func main() {
+	flag.Parse()
+
+	if *inPlace && *configPath == "" {
+		log.Fatal("must set --config-path with --in-place")
+	}
+	if !strings.Contains(*topicTemplate, "{field}") {
+		log.Fatalf("topic template must contain {field}, found %q", *topicTemplate)
+	}
+
+	values := map[string]interface{}{}
+	outFormat := config.FormatJSON
+	if *configPath != "" {
+		// A missing config is started afresh, so that --in-place can create it.
+		existing, err := config.ReadFile(*configPath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatalf("could not read config: %v", err)
+		}
+		if err == nil {
+			values = existing
+		}
+		outFormat = config.FormatForPath(*configPath)
+	}
+	switch f := config.Format(*format); f {
+	case "":
+	case config.FormatJSON, config.FormatYAML, config.FormatTOML:
+		outFormat = f
+	default:
+		log.Fatalf("format must be %v, %v, or %v, found %q", config.FormatJSON, config.FormatYAML, config.FormatTOML, *format)
+	}
+
+	if _, ok := values["mqttBroker"]; !ok {
+		values["mqttBroker"] = *broker
+	}
+
+	bulbs := discover()
+	if len(bulbs) == 0 {
+		log.Print("found no bulbs")
+	}
+	addBulbs(values, bulbs)
+
+	// The config is still written with problems, e.g. a template variable that some bulbs lack, so they can be fixed by hand.
+	data, err := json.Marshal(values)
+	if err != nil {
+		log.Fatalf("could not marshal config: %v", err)
+	}
+	if _, err := config.Parse(data); err != nil {
+		log.Printf("the config needs editing:\n%v", err)
+	}
+
+	out, err := config.Encode(outFormat, values)
+	if err != nil {
+		log.Fatalf("could not encode config: %v", err)
+	}
+	if !*inPlace {
+		os.Stdout.Write(out)
+		return
+	}
+	if err := writeFile(*configPath, out); err != nil {
+		log.Fatalf("could not write config: %v", err)
+	}
+}
+
+// discover returns the label and info of every bulb that responds, sorted by label.
+func discover() []discovered {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	bulbs, err := lifx.Discover(ctx)
+	if err != nil {
+		log.Fatalf("could not discover bulbs: %v", err)
+	}
+
+	var found []discovered
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, bulb := range bulbs {
+		bulb := bulb
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+			state, err := bulb.State(ctx)
+			if err != nil {
+				log.Printf("a bulb was discovered but we could not query it: %v", err)
+				return
+			}
+			info, err := bulb.Info(ctx)
+			if err != nil {
+				log.Printf("could not read info of bulb %q: %v", state.Label, err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			found = append(found, discovered{label: state.Label, info: info})
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(found, func(i, j int) bool { return found[i].label < found[j].label })
+	return found
+}
+
+// addBulbs adds the bulbs, and the groups they are in, that are not already configured.
+func addBulbs(values map[string]interface{}, found []discovered) {
+	bulbs := object(values, "bulbs")
+	groups := object(values, "groups")
+	defer func() {
+		if len(groups) == 0 {
+			delete(values, "groups")
+		}
+	}()
+	bulbLabels := configuredLabels(bulbs)
+	labels := configuredLabels(groups)
+	for label := range bulbLabels {
+		labels[label] = true
+	}
+
+	foundLabels := map[string]bool{}
+	membersByGroup := map[string][]string{}
+	for _, d := range found {
+		foundLabels[d.label] = true
+		if d.info.Group != "" {
+			membersByGroup[d.info.Group] = append(membersByGroup[d.info.Group], d.label)
+		}
+
+		if labels[d.label] {
+			log.Printf("kept bulb %q, which is already configured", d.label)
+			continue
+		}
+		bulb, err := newBulb(values, d.label, config.DiscoveredVariables(d.info))
+		if err != nil {
+			log.Printf("could not add bulb %q: %v", d.label, err)
+			continue
+		}
+		bulbs[d.label] = bulb
+		labels[d.label] = true
+		log.Printf("added bulb %q: %v, %v, firmware %v, in group %q", d.label, d.info.Product.Name, d.info.MAC, d.info.Firmware, d.info.Group)
+	}
+
+	for label := range bulbLabels {
+		if !foundLabels[label] {
+			log.Printf("did not find configured bulb %q", label)
+		}
+	}
+
+	if !*addGroups {
+		return
+	}
+	var names []string
+	for name := range membersByGroup {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if labels[name] {
+			log.Printf("kept group %q, which is already configured", name)
+			continue
+		}
+		group, err := newBulb(values, name, map[string]string{"group": homie.DeviceID(name)})
+		if err != nil {
+			log.Printf("could not add group %q: %v", name, err)
+			continue
+		}
+		group["bulbs"] = membersByGroup[name]
+		groups[name] = group
+		labels[name] = true
+		log.Printf("added group %q of %v", name, strings.Join(membersByGroup[name], ", "))
+	}
+}
+
+// newBulb returns the config for a bulb or group.
+// Homie bulbs need no topics, bulbs of a config that has its own topicTemplate only need their variables, and otherwise the topics are written out from --topic-template.
+func newBulb(values map[string]interface{}, label string, variables map[string]string) (map[string]interface{}, error) {
+	if layout, _ := values["layout"].(string); config.Layout(layout) == config.LayoutHomie {
+		return map[string]interface{}{}, nil
+	}
+	if _, ok := values["topicTemplate"]; ok {
+		return map[string]interface{}{"variables": variables}, nil
+	}
+
+	t := &config.TopicTemplate{Template: *topicTemplate}
+	topics, err := t.Topics(label, variables)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"topics": jsonValue(topics)}, nil
+}
+
+// configuredLabels returns the labels of configured bulbs or groups, which are their keys unless they set a label.
+func configuredLabels(entries map[string]interface{}) map[string]bool {
+	labels := map[string]bool{}
+	for k, v := range entries {
+		label := k
+		if fields, ok := v.(map[string]interface{}); ok {
+			if l, ok := fields["label"].(string); ok && l != "" {
+				label = l
+			}
+		}
+		labels[label] = true
+	}
+	return labels
+}
+
+// object returns a field of the config that is an object, adding it if it is missing.
+func object(values map[string]interface{}, name string) map[string]interface{} {
+	if o, ok := values[name].(map[string]interface{}); ok {
+		return o
+	}
+	if v, ok := values[name]; ok && v != nil {
+		log.Fatalf("config field %v must be an object, found %T", name, v)
+	}
+	o := map[string]interface{}{}
+	values[name] = o
+	return o
+}
+
+// jsonValue returns v as the values ReadFile would, so that every format encodes it the same way.
+func jsonValue(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("could not marshal %T: %v", v, err))
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		panic(fmt.Sprintf("could not unmarshal %T: %v", v, err))
+	}
+	return value
+}
+
+// writeFile replaces a file by renaming a new file over it, so that a running bridge never reloads half of it.
+func writeFile(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("could not replace %v: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,513 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"go.eth.moe/catbus"
+	"go.eth.moe/catbus-lifx/config"
+	"go.eth.moe/catbus-lifx/homeassistant"
+	"go.eth.moe/catbus-lifx/homie"
+	"go.eth.moe/catbus-lifx/lifx"
+	"go.eth.moe/logger"
+)
+
+var (
+	// availabilitiesByLabel are where to publish each bulb's availability, if anywhere.
+	availabilitiesByLabel = map[string]config.Availability{}
+
+	// pipelinesByLabel apply the commands for each bulb in order.
+	pipelinesByLabel = map[string]*pipeline{}
+
+	// bulbsMu guards availabilitiesByLabel, pipelinesByLabel, and effectTopicsByLabel, which gain bulbs as they are auto-configured.
+	bulbsMu sync.Mutex
+
+	// scenesByName and sceneTopic are the scenes of the current config, which change when it is reloaded.
+	scenesByName   = map[string]config.Scene{}
+	sceneTopic     string
+	scenesByNameMu sync.Mutex
+
+	// transitionsByLabel overrides how long each bulb smooths changes over.
+	transitionsByLabel   = map[string]time.Duration{}
+	transitionsByLabelMu sync.Mutex
+)
+
+// addBulb prepares the actuator for commands to a bulb.
+// A bulb that is added again, e.g. an auto-configured bulb that went missing and came back, or a bulb whose config was reloaded, keeps its pipeline.
+func addBulb(c *config.Config, bulb config.Bulb) {
+	bulbsMu.Lock()
+	defer bulbsMu.Unlock()
+
+	if p, ok := pipelinesByLabel[bulb.Label]; ok {
+		p.setWindow(bulb.CoalesceWindow)
+	} else {
+		pipelinesByLabel[bulb.Label] = newPipeline(bulb.Label, bulb.CoalesceWindow)
+	}
+	if availability, ok := c.BulbAvailability(bulb); ok {
+		availabilitiesByLabel[bulb.Label] = availability
+	}
+	if topic, ok := c.EffectTopic(bulb); ok {
+		effectTopicsByLabel[bulb.Label] = topic
+	}
+}
+
+// removeBulb stops publishing a bulb's availability and effect.
+func removeBulb(label string) {
+	bulbsMu.Lock()
+	defer bulbsMu.Unlock()
+	delete(availabilitiesByLabel, label)
+	delete(effectTopicsByLabel, label)
+}
+
+func pipelineFor(label string) *pipeline {
+	bulbsMu.Lock()
+	defer bulbsMu.Unlock()
+	return pipelinesByLabel[label]
+}
+
+// subscribeBulbs subscribes to the commands for every bulb, including those that have been auto-configured.
+func subscribeBulbs(broker catbus.Client, c *config.Config) {
+	for _, d := range devices.devices() {
+		subscribeBulb(broker, c, d.config)
+	}
+}
+
+func subscribeBulb(broker catbus.Client, c *config.Config, bulb config.Bulb) {
+	log := logger.Background()
+	log.AddField("bulb", bulb.Label)
+
+	for topic, handler := range handlersByTopic(c, bulb) {
+		if err := broker.Subscribe(topic, handler); err != nil {
+			log := log.WithError(err)
+			log.AddField("topic", topic)
+			log.Error("could not subscribe to bulb topic")
+		}
+	}
+}
+
+func unsubscribeBulb(broker catbus.Client, c *config.Config, bulb config.Bulb) {
+	log := logger.Background()
+	log.AddField("bulb", bulb.Label)
+
+	for topic := range handlersByTopic(c, bulb) {
+		if err := broker.Unsubscribe(topic); err != nil {
+			log := log.WithError(err)
+			log.AddField("topic", topic)
+			log.Error("could not unsubscribe from bulb topic")
+		}
+	}
+}
+
+// handlersByTopic returns the handler for each of a bulb's command topics.
+func handlersByTopic(c *config.Config, bulb config.Bulb) map[string]catbus.MessageHandler {
+	label := bulb.Label
+
+	if c.Layout == config.LayoutHomie {
+		deviceID := homie.DeviceID(label)
+		handlersByProperty := map[string]catbus.MessageHandler{
+			homie.PropertyPower:      setHomiePower(label),
+			homie.PropertyHue:        setField(label, fieldHue),
+			homie.PropertySaturation: setField(label, fieldSaturation),
+			homie.PropertyBrightness: setField(label, fieldBrightness),
+			homie.PropertyKelvin:     setField(label, fieldKelvin),
+			homie.PropertyTransition: setHomieTransition(c.Homie, label),
+			homie.PropertyEffect:     setEffect(label),
+		}
+		handlers := map[string]catbus.MessageHandler{}
+		for property, handler := range handlersByProperty {
+			handlers[homie.SetTopic(c.Homie.BaseTopic, deviceID, property)] = handler
+		}
+
+		// The transition is not part of the bulb's state, so restore it from its retained value.
+		handlers[homie.PropertyTopic(c.Homie.BaseTopic, deviceID, homie.PropertyTransition)] = restoreHomieTransition(label)
+		return handlers
+	}
+
+	handlers := map[string]catbus.MessageHandler{
+		bulb.Topics.Power:      setPower(label),
+		bulb.Topics.Hue:        setField(label, fieldHue),
+		bulb.Topics.Saturation: setField(label, fieldSaturation),
+		bulb.Topics.Brightness: setField(label, fieldBrightness),
+		bulb.Topics.Kelvin:     setField(label, fieldKelvin),
+	}
+	if bulb.Topics.Effect != "" {
+		handlers[bulb.Topics.Effect] = setEffect(label)
+	}
+	return handlers
+}
+
+// reportAvailability publishes a bulb as online if a command succeeded, or offline if the bulb did not respond.
+func reportAvailability(broker catbus.Client, label string, err error) {
+	bulbsMu.Lock()
+	availability, ok := availabilitiesByLabel[label]
+	bulbsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	payload := availability.Online
+	if err != nil {
+		if !errors.Is(err, lifx.ErrNoResponse) {
+			return
+		}
+		payload = availability.Offline
+	}
+
+	if err := publishChanged(broker, availability.Topic, payload); err != nil {
+		log := logger.Background()
+		log.AddField("bulb", label)
+		log.WithError(err).Error("could not publish availability")
+	}
+}
+
+// transition returns how long to smooth a change to a bulb over, if it has been set, or otherwise a default.
+func transition(label string, d time.Duration) time.Duration {
+	transitionsByLabelMu.Lock()
+	defer transitionsByLabelMu.Unlock()
+	if t, ok := transitionsByLabel[label]; ok {
+		return t
+	}
+	return d
+}
+
+func setPower(label string) catbus.MessageHandler {
+	return func(broker catbus.Client, msg catbus.Message) {
+		if isEcho(msg) {
+			return
+		}
+		log := logger.Background()
+		log.AddField("bulb", label)
+		log.AddField("payload", msg.Payload)
+
+		var a adjustment
+		switch msg.Payload {
+		case "on":
+			a = setTo(1)
+		case "off":
+			a = setTo(0)
+		case "toggle":
+			a = adjustment{operator: toggle}
+		default:
+			log.Warning("invalid power state")
+			return
+		}
+
+		pipelineFor(label).send(broker, command{
+			adjustmentsByField: map[field][]adjustment{fieldPower: {a}},
+			colorTransition:    transition(label, 100*time.Millisecond),
+			powerTransition:    transition(label, 500*time.Millisecond),
+		})
+	}
+}
+func setField(label string, f field) catbus.MessageHandler {
+	return func(broker catbus.Client, msg catbus.Message) {
+		if isEcho(msg) {
+			return
+		}
+		log := logger.Background()
+		log.AddField("bulb", label)
+		log.AddField("payload", msg.Payload)
+
+		a, err := parseAdjustment(msg.Payload)
+		if err != nil {
+			log.WithError(err).Warning("invalid " + string(f))
+			return
+		}
+
+		pipelineFor(label).send(broker, command{
+			adjustmentsByField: map[field][]adjustment{f: {a}},
+			colorTransition:    transition(label, 100*time.Millisecond),
+			powerTransition:    transition(label, 500*time.Millisecond),
+		})
+	}
+}
+
+func setHomeAssistant(ha *config.HomeAssistant) catbus.MessageHandler {
+	return func(broker catbus.Client, msg catbus.Message) {
+		if isEcho(msg) {
+			return
+		}
+		log := logger.Background()
+		log.AddField("topic", msg.Topic)
+		log.AddField("payload", msg.Payload)
+
+		id, ok := homeassistant.IDFromCommandTopic(ha.TopicPrefix, msg.Topic)
+		if !ok {
+			log.Warning("invalid Home Assistant command topic")
+			return
+		}
+
+		label, ok := devices.labelForHomeAssistantID(id)
+		if !ok {
+			log.Error("could not find bulb")
+			return
+		}
+		log.AddField("bulb", label)
+
+		cmd := homeassistant.State{}
+		if err := json.Unmarshal([]byte(msg.Payload), &cmd); err != nil {
+			log.WithError(err).Warning("invalid Home Assistant command")
+			return
+		}
+		power, powerChange, err := cmd.Power()
+		if err != nil {
+			log.WithError(err).Warning("invalid Home Assistant command")
+			return
+		}
+
+		c := command{
+			adjustmentsByField: map[field][]adjustment{},
+			colorTransition:    transition(label, 100*time.Millisecond),
+			powerTransition:    transition(label, 500*time.Millisecond),
+		}
+		if cmd.Transition != nil {
+			c.colorTransition = time.Duration(*cmd.Transition * float64(time.Second))
+			c.powerTransition = c.colorTransition
+		}
+		if powerChange {
+			c.adjustmentsByField[fieldPower] = []adjustment{setTo(0)}
+			if power == lifx.On {
+				c.adjustmentsByField[fieldPower] = []adjustment{setTo(1)}
+			}
+		}
+		if cmd.Brightness != nil {
+			c.adjustmentsByField[fieldBrightness] = []adjustment{setTo(*cmd.Brightness)}
+		}
+		if cmd.Color != nil {
+			c.adjustmentsByField[fieldHue] = []adjustment{setTo(int(cmd.Color.Hue))}
+			c.adjustmentsByField[fieldSaturation] = []adjustment{setTo(int(cmd.Color.Saturation))}
+		}
+		// Lifx bulbs render pure white when saturation is 0.
+		if cmd.ColorTemp != nil {
+			c.adjustmentsByField[fieldKelvin] = []adjustment{setTo(*cmd.ColorTemp)}
+			c.adjustmentsByField[fieldSaturation] = []adjustment{setTo(0)}
+		}
+
+		pipelineFor(label).send(broker, c)
+	}
+}
+
+func setScenes(c *config.Config) {
+	scenesByNameMu.Lock()
+	defer scenesByNameMu.Unlock()
+	scenesByName = c.ScenesByName
+	sceneTopic = c.SceneTopic
+}
+
+func sceneByName(name string) (config.Scene, bool) {
+	scenesByNameMu.Lock()
+	defer scenesByNameMu.Unlock()
+	scene, ok := scenesByName[name]
+	return scene, ok
+}
+
+// subscribeScenes subscribes to the scene topic of the current config, if there are any scenes.
+func subscribeScenes(broker catbus.Client) {
+	scenesByNameMu.Lock()
+	n, topic := len(scenesByName), sceneTopic
+	scenesByNameMu.Unlock()
+	if n == 0 {
+		return
+	}
+
+	if err := broker.Subscribe(topic, setScene()); err != nil {
+		log := logger.Background().WithError(err)
+		log.AddField("topic", topic)
+		log.Error("could not subscribe to scenes")
+	}
+}
+
+// setScene applies a scene to all of its bulbs together.
+func setScene() catbus.MessageHandler {
+	return func(broker catbus.Client, msg catbus.Message) {
+		log := logger.Background()
+		log.AddField("scene", msg.Payload)
+
+		// Scenes are triggers rather than state, so do not reapply a retained scene on every reconnect.
+		if msg.Retained {
+			return
+		}
+
+		scene, ok := sceneByName(msg.Payload)
+		if !ok {
+			log.Warning("unknown scene")
+			return
+		}
+		applyScene(broker, scene)
+		log.Info("applied scene")
+	}
+}
+
+// applyScene sets the bulbs of a scene, and the members of its groups, in the same instant.
+// Bulbs set to the same color, or the same power, are sent it together, as with a group.
+func applyScene(broker catbus.Client, scene config.Scene) {
+	log, ctx := logger.FromContext(context.Background())
+	log.AddField("scene", scene.Name)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// Groups are set through their members, so that bulbs in several groups, or in a group and on their own, are only sent one color.
+	devicesByLabel := map[string]device{}
+	statesByLabel := map[string]lifx.State{}
+	for label, state := range scene.StatesByLabel {
+		cancelEffects(broker, label)
+		for _, l := range devices.related(label) {
+			pauseAdaptive(l)
+		}
+
+		d, ok := devices.device(label)
+		if !ok {
+			continue
+		}
+		labels := []string{label}
+		if len(d.members) > 0 {
+			labels = d.members
+		}
+		for _, l := range labels {
+			m, ok := devices.device(l)
+			if !ok || m.bulb == nil {
+				log := logger.Background()
+				log.AddField("scene", scene.Name)
+				log.AddField("bulb", l)
+				log.Warning("could not find bulb for scene")
+				continue
+			}
+			devicesByLabel[l] = m
+			statesByLabel[l] = state
+		}
+	}
+
+	// As with a single bulb, bulbs that are turning on change color first so they do not flash the old color.
+	type colorChange struct {
+		color      lifx.HSBK
+		transition time.Duration
+	}
+	labelsByColor := map[colorChange][]string{}
+	labelsByPower := map[lifx.Power][]string{}
+	for label, state := range statesByLabel {
+		change := colorChange{state.Color, scene.Transition}
+		if state.Power == lifx.On && devicesByLabel[label].state.Power == lifx.Off {
+			change.transition = 0
+		}
+		labelsByColor[change] = append(labelsByColor[change], label)
+		labelsByPower[state.Power] = append(labelsByPower[state.Power], label)
+	}
+
+	failed := map[string]error{}
+	var mu sync.Mutex
+	together := func(labels []string, f func(lifx.Bulb) error) {
+		var members []lifx.Bulb
+		for _, label := range labels {
+			members = append(members, devicesByLabel[label].bulb)
+		}
+		if err := f(lifx.NewGroup(scene.Name, members...)); err != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, label := range labels {
+				failed[label] = err
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for change, labels := range labelsByColor {
+		change, labels := change, labels
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			together(labels, func(b lifx.Bulb) error { return b.SetColor(ctx, change.color, change.transition) })
+		}()
+	}
+	wg.Wait()
+	for power, labels := range labelsByPower {
+		power, labels := power, labels
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			together(labels, func(b lifx.Bulb) error { return b.SetPower(ctx, power, scene.Transition) })
+		}()
+	}
+	wg.Wait()
+
+	for label, state := range statesByLabel {
+		err := failed[label]
+		reportAvailability(broker, label, err)
+		if err != nil {
+			log := log.WithError(err)
+			log.AddField("bulb", label)
+			log.Error("could not set bulb for scene")
+			continue
+		}
+		state.Label = label
+		devices.setState(label, state)
+	}
+	for label, state := range scene.StatesByLabel {
+		if d, ok := devices.device(label); ok && len(d.members) > 0 {
+			state.Label = label
+			devices.setState(label, state)
+		}
+	}
+}
+
+func setHomiePower(label string) catbus.MessageHandler {
+	setPower := setPower(label)
+	return func(broker catbus.Client, msg catbus.Message) {
+		if msg.Payload == "toggle" {
+			setPower(broker, msg)
+			return
+		}
+		power, err := homie.ParsePower(msg.Payload)
+		if err != nil {
+			log := logger.Background()
+			log.AddField("bulb", label)
+			log.AddField("payload", msg.Payload)
+			log.WithError(err).Warning("invalid power state")
+			return
+		}
+		msg.Payload = power.String()
+		setPower(broker, msg)
+	}
+}
+func setHomieTransition(h config.Homie, label string) catbus.MessageHandler {
+	return func(broker catbus.Client, msg catbus.Message) {
+		log := logger.Background()
+		log.AddField("bulb", label)
+		log.AddField("payload", msg.Payload)
+
+		d, err := homie.ParseTransition(msg.Payload)
+		if err != nil {
+			log.WithError(err).Warning("invalid transition")
+			return
+		}
+
+		transitionsByLabelMu.Lock()
+		transitionsByLabel[label] = d
+		transitionsByLabelMu.Unlock()
+
+		topic := homie.PropertyTopic(h.BaseTopic, homie.DeviceID(label), homie.PropertyTransition)
+		if err := broker.Publish(topic, catbus.Retain, homie.TransitionValue(d)); err != nil {
+			log.WithError(err).Error("could not publish transition")
+			return
+		}
+		log.Info("set transition")
+	}
+}
+func restoreHomieTransition(label string) catbus.MessageHandler {
+	return func(_ catbus.Client, msg catbus.Message) {
+		d, err := homie.ParseTransition(msg.Payload)
+		if err != nil {
+			return
+		}
+
+		transitionsByLabelMu.Lock()
+		defer transitionsByLabelMu.Unlock()
+		transitionsByLabel[label] = d
+	}
+}
@@ -0,0 +1,321 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.eth.moe/catbus"
+	"go.eth.moe/catbus-lifx/config"
+	"go.eth.moe/catbus-lifx/lifx"
+)
+
+const testConfig = `{
+	"mqttBroker": "tcp://localhost:1883",
+	"bulbs": {
+		"Lamp": {
+			"topics": {
+				"power": "lamp/power",
+				"hue": "lamp/hue",
+				"saturation": "lamp/saturation",
+				"brightness": "lamp/brightness",
+				"kelvin": "lamp/kelvin",
+				"effect": "lamp/effect"
+			},
+			"coalesceWindow": "10ms"
+		}
+	}
+}`
+
+type (
+	// fakeBroker sends every message to the subscribers of its topic, including the client that published it, as a broker does.
+	fakeBroker struct {
+		mu              sync.Mutex
+		handlersByTopic map[string]catbus.MessageHandler
+
+		messages chan catbus.Message
+		pending  sync.WaitGroup
+	}
+
+	// fakeBulb is a bulb that records the colors it is set to.
+	fakeBulb struct {
+		mu     sync.Mutex
+		state  lifx.State
+		colors []colorCall
+	}
+
+	colorCall struct {
+		color      lifx.HSBK
+		transition time.Duration
+	}
+)
+
+func newFakeBroker() *fakeBroker {
+	b := &fakeBroker{
+		handlersByTopic: map[string]catbus.MessageHandler{},
+		messages:        make(chan catbus.Message, 100),
+	}
+	go func() {
+		for msg := range b.messages {
+			b.mu.Lock()
+			handler, ok := b.handlersByTopic[msg.Topic]
+			b.mu.Unlock()
+			if ok {
+				handler(b, msg)
+			}
+			b.pending.Done()
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) Connect() error { return nil }
+
+func (b *fakeBroker) Publish(topic string, _ catbus.Retention, payload string) error {
+	b.pending.Add(1)
+	b.messages <- catbus.Message{Topic: topic, Payload: payload}
+	return nil
+}
+
+func (b *fakeBroker) Subscribe(topic string, f catbus.MessageHandler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlersByTopic[topic] = f
+	return nil
+}
+
+func (b *fakeBroker) Unsubscribe(topic string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.handlersByTopic, topic)
+	return nil
+}
+
+// flush waits for every message published so far to be handled.
+func (b *fakeBroker) flush() {
+	b.pending.Wait()
+}
+
+func (b *fakeBulb) Info(context.Context) (lifx.Info, error) { return lifx.Info{}, nil }
+func (b *fakeBulb) Signal(context.Context) (int, error)     { return -50, nil }
+
+func (b *fakeBulb) State(context.Context) (lifx.State, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, nil
+}
+
+func (b *fakeBulb) SetPower(_ context.Context, p lifx.Power, _ time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state.Power = p
+	return nil
+}
+
+func (b *fakeBulb) SetColor(_ context.Context, color lifx.HSBK, d time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state.Color = color
+	b.colors = append(b.colors, colorCall{color, d})
+	return nil
+}
+
+func (b *fakeBulb) colorCalls() []colorCall {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]colorCall{}, b.colors...)
+}
+
+// newTestBridge sets up an observing and actuating bridge for testConfig, whose "Lamp" is a fakeBulb that is on.
+func newTestBridge(t *testing.T) (*config.Config, *fakeBroker, *fakeBulb) {
+	t.Helper()
+	c, err := config.Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("could not parse config: %v", err)
+	}
+
+	bulbsMu.Lock()
+	pipelinesByLabel = map[string]*pipeline{}
+	availabilitiesByLabel = map[string]config.Availability{}
+	effectTopicsByLabel = map[string]string{}
+	bulbsMu.Unlock()
+	effectsByLabelMu.Lock()
+	effectsByLabel = map[string]runningEffect{}
+	effectsByLabelMu.Unlock()
+	adaptiveByLabelMu.Lock()
+	adaptiveByLabel = map[string]*adaptiveBulb{}
+	adaptiveByLabelMu.Unlock()
+	forgetPublished()
+	echoesByTopicMu.Lock()
+	echoesByTopic = map[string][]string{}
+	echoesByTopicMu.Unlock()
+
+	devices = newRegistry(c)
+	for _, bulb := range c.BulbsByLabel {
+		addBulb(c, bulb)
+	}
+
+	broker := newFakeBroker()
+	bulb := &fakeBulb{state: lifx.State{
+		Label: "Lamp",
+		Power: lifx.On,
+		Color: lifx.HSBK{Hue: 0, Saturation: 0, Brightness: 50, Kelvin: 3500},
+	}}
+	devices.update("Lamp", func(d *device) {
+		d.bulb = bulb
+		d.state = bulb.state
+	})
+	devices.onChange = func(d device) {
+		publishBulbState(c, broker, d)
+	}
+	publishBulbStates(c, broker)
+	subscribeBulbs(broker, c)
+	broker.flush()
+	return c, broker, bulb
+}
+
+func TestAdaptiveIgnoresOwnState(t *testing.T) {
+	_, broker, _ := newTestBridge(t)
+	adaptiveConfig = &config.Adaptive{
+		MinKelvin:     2700,
+		MaxKelvin:     2700,
+		MinBrightness: 30,
+		MaxBrightness: 30,
+		Interval:      time.Minute,
+	}
+	setAdaptive("Lamp", true)
+
+	adaptBulbs(context.Background(), adaptiveConfig)
+	broker.flush()
+
+	adaptiveByLabelMu.Lock()
+	defer adaptiveByLabelMu.Unlock()
+	ab := adaptiveByLabel["Lamp"]
+	if !ab.adjusted {
+		t.Errorf("adaptive white did not adjust the bulb")
+	}
+	if ab.paused {
+		t.Errorf("adaptive white was paused by the bridge's own state")
+	}
+}
+
+func TestEffectSurvivesOwnState(t *testing.T) {
+	_, broker, bulb := newTestBridge(t)
+
+	broker.Publish("lamp/effect", catbus.Retain, "cycle")
+	broker.flush()
+	defer stopEffect("Lamp", false)
+
+	// The effect changes the bulb, which the bridge polls and publishes.
+	state, _ := bulb.State(context.Background())
+	state.Color.Hue = 120
+	devices.setState("Lamp", state)
+	broker.flush()
+
+	effectsByLabelMu.Lock()
+	running, ok := effectsByLabel["Lamp"]
+	effectsByLabelMu.Unlock()
+	if !ok || running.payload != "cycle" {
+		t.Errorf("effect was cancelled by the bridge's own state")
+	}
+}
+
+func TestAlarmSurvivesOwnState(t *testing.T) {
+	_, broker, bulb := newTestBridge(t)
+
+	done := make(chan struct{})
+	go func() {
+		wake(context.Background(), broker, "Lamp", time.Hour)
+		close(done)
+	}()
+	defer func() {
+		stopEffect("Lamp", false)
+		<-done
+	}()
+
+	running := func() bool {
+		effectsByLabelMu.Lock()
+		defer effectsByLabelMu.Unlock()
+		e, ok := effectsByLabel["Lamp"]
+		return ok && e.payload == "alarm"
+	}
+	for deadline := time.Now().Add(time.Second); !running() || len(bulb.colorCalls()) < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("alarm did not start")
+		}
+	}
+
+	// The ramp changes the bulb, which the bridge polls and publishes.
+	state, _ := bulb.State(context.Background())
+	devices.setState("Lamp", state)
+	broker.flush()
+
+	if !running() {
+		t.Errorf("alarm was cancelled by the bridge's own state")
+	}
+}
+
+func TestLongTransitionSurvivesOwnState(t *testing.T) {
+	brightness := 100
+	tests := []struct {
+		name  string
+		apply func(broker catbus.Client)
+	}{
+		{
+			name: "scene",
+			apply: func(broker catbus.Client) {
+				applyScene(broker, config.Scene{
+					Name:       "Bright",
+					Transition: 5 * time.Second,
+					StatesByLabel: map[string]lifx.State{
+						"Lamp": {Power: lifx.On, Color: lifx.HSBK{Brightness: 100, Kelvin: 3500}},
+					},
+				})
+			},
+		},
+		{
+			name: "schedule",
+			apply: func(broker catbus.Client) {
+				pipelineFor("Lamp").send(broker, commandForSchedule(config.Schedule{
+					Label:      "Lamp",
+					Brightness: &brightness,
+					Transition: 5 * time.Second,
+				}))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		_, broker, bulb := newTestBridge(t)
+
+		tt.apply(broker)
+		set := func() bool {
+			d, _ := devices.device("Lamp")
+			return d.state.Color.Brightness == 100
+		}
+		for deadline := time.Now().Add(time.Second); !set(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%v: did not set the bulb", tt.name)
+			}
+		}
+		broker.flush()
+
+		// Partway through the transition, the bridge polls and publishes the bulb's brightness so far.
+		state, _ := bulb.State(context.Background())
+		state.Color.Brightness = 75
+		devices.setState("Lamp", state)
+		broker.flush()
+		// Any command from the echo would be applied after the bulb's 10ms coalesce window.
+		time.Sleep(50 * time.Millisecond)
+
+		calls := bulb.colorCalls()
+		if len(calls) != 1 || calls[0].transition != 5*time.Second {
+			t.Errorf("%v: set colors %+v, want only the 5s transition", tt.name, calls)
+		}
+	}
+}
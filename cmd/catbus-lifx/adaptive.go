@@ -0,0 +1,234 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"go.eth.moe/catbus-lifx/config"
+	"go.eth.moe/catbus-lifx/lifx"
+	"go.eth.moe/catbus-lifx/solar"
+	"go.eth.moe/logger"
+)
+
+type (
+	// adaptiveBulb is the progress of adaptive white for a bulb.
+	adaptiveBulb struct {
+		// paused is whether a manual change paused adaptation, until the bulb is turned off and on again.
+		paused bool
+
+		// from and to are the colors of the bulb's last adjustment, between which it transitions.
+		from, to lifx.HSBK
+		adjusted bool
+	}
+)
+
+var (
+	// adaptiveConfig is nil if no bulbs are adaptive.
+	adaptiveConfig *config.Adaptive
+
+	adaptiveByLabel   = map[string]*adaptiveBulb{}
+	adaptiveByLabelMu sync.Mutex
+)
+
+// setAdaptive starts or stops a bulb following the sun, e.g. when the config is reloaded.
+func setAdaptive(label string, adaptive bool) {
+	adaptiveByLabelMu.Lock()
+	defer adaptiveByLabelMu.Unlock()
+
+	_, ok := adaptiveByLabel[label]
+	switch {
+	case adaptive && !ok:
+		adaptiveByLabel[label] = &adaptiveBulb{}
+	case !adaptive && ok:
+		delete(adaptiveByLabel, label)
+	}
+}
+
+// adaptiveColor returns the kelvin and brightness for adaptive bulbs at a given time.
+// They follow a sine curve from their minimums at sunrise, to their maximums at solar noon, and back to their minimums at sunset.
+func adaptiveColor(a *config.Adaptive, now time.Time) (kelvin, brightness int) {
+	level := 0.0
+	if sunrise, sunset, ok := solar.Times(now, a.Latitude, a.Longitude); ok {
+		if now.After(sunrise) && now.Before(sunset) {
+			level = math.Sin(math.Pi * float64(now.Sub(sunrise)) / float64(sunset.Sub(sunrise)))
+		}
+	} else if isSummer(now, a.Latitude) {
+		// The sun neither rises nor sets in a polar summer.
+		level = 1
+	}
+
+	kelvin = a.MinKelvin + int(math.Round(level*float64(a.MaxKelvin-a.MinKelvin)))
+	brightness = a.MinBrightness + int(math.Round(level*float64(a.MaxBrightness-a.MinBrightness)))
+	return kelvin, brightness
+}
+
+// isSummer returns whether it is summer in a hemisphere, for telling polar day from polar night.
+func isSummer(now time.Time, latitude float64) bool {
+	northernSummer := now.Month() >= time.April && now.Month() <= time.September
+	return northernSummer == (latitude >= 0)
+}
+
+// adapt adjusts adaptive bulbs to follow the sun, until ctx is done.
+func adapt(ctx context.Context, c *config.Config) {
+	ticker := time.NewTicker(c.Adaptive.Interval)
+	defer ticker.Stop()
+	for {
+		adaptBulbs(ctx, c.Adaptive)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func adaptBulbs(ctx context.Context, a *config.Adaptive) {
+	kelvin, brightness := adaptiveColor(a, time.Now())
+
+	adaptiveByLabelMu.Lock()
+	var labels []string
+	for label, ab := range adaptiveByLabel {
+		if !ab.paused {
+			labels = append(labels, label)
+		}
+	}
+	adaptiveByLabelMu.Unlock()
+
+	for _, label := range labels {
+		log := logger.Background()
+		log.AddField("bulb", label)
+
+		d, ok := devices.device(label)
+		if !ok || d.bulb == nil || d.unreachable || d.state.Power != lifx.On {
+			continue
+		}
+		effectsByLabelMu.Lock()
+		_, effect := effectsByLabel[label]
+		effectsByLabelMu.Unlock()
+		if effect {
+			continue
+		}
+
+		color := d.state.Color
+		color.Saturation = 0
+		color.Kelvin = kelvin
+		color.Brightness = brightness
+		if color == d.state.Color {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := d.bulb.SetColor(ctx, color, a.Interval)
+		cancel()
+		if err != nil {
+			log.WithError(err).Error("could not adapt bulb")
+			continue
+		}
+
+		// The bulb may have stopped being adaptive, e.g. by a reload, since the lock was released.
+		adaptiveByLabelMu.Lock()
+		ab, ok := adaptiveByLabel[label]
+		if ok {
+			ab.from, ab.to, ab.adjusted = d.state.Color, color, true
+		}
+		adaptiveByLabelMu.Unlock()
+		if !ok {
+			continue
+		}
+
+		state := d.state
+		state.Color = color
+		devices.setState(label, state)
+
+		log.AddField("kelvin", kelvin)
+		log.AddField("brightness", brightness)
+		log.Info("adapted bulb")
+	}
+}
+
+// turnOnAdaptive returns the color an adaptive bulb should turn on with, if it is adaptive and not paused.
+func turnOnAdaptive(label string, color lifx.HSBK) (lifx.HSBK, bool) {
+	adaptiveByLabelMu.Lock()
+	ab, ok := adaptiveByLabel[label]
+	adaptiveByLabelMu.Unlock()
+	if !ok || ab.paused {
+		return color, false
+	}
+
+	from := color
+	color.Saturation = 0
+	color.Kelvin, color.Brightness = adaptiveColor(adaptiveConfig, time.Now())
+
+	adaptiveByLabelMu.Lock()
+	ab.from, ab.to, ab.adjusted = from, color, true
+	adaptiveByLabelMu.Unlock()
+	return color, true
+}
+
+// pauseAdaptive pauses adaptation for a bulb, after a manual change.
+func pauseAdaptive(label string) {
+	adaptiveByLabelMu.Lock()
+	defer adaptiveByLabelMu.Unlock()
+
+	ab, ok := adaptiveByLabel[label]
+	if !ok || ab.paused {
+		return
+	}
+	ab.paused = true
+
+	log := logger.Background()
+	log.AddField("bulb", label)
+	log.Info("paused adaptive white")
+}
+
+// checkAdaptive resumes adaptation for a bulb once it is turned off, and pauses it if its color is changed by something else, e.g. the Lifx app.
+func checkAdaptive(label string, state lifx.State) {
+	adaptiveByLabelMu.Lock()
+	ab, ok := adaptiveByLabel[label]
+	if !ok {
+		adaptiveByLabelMu.Unlock()
+		return
+	}
+
+	if state.Power == lifx.Off {
+		// Whatever color the bulb is turned on with, it is adjusted at the next interval.
+		resumed := ab.paused
+		ab.paused = false
+		ab.adjusted = false
+		adaptiveByLabelMu.Unlock()
+
+		if resumed {
+			log := logger.Background()
+			log.AddField("bulb", label)
+			log.Info("resumed adaptive white")
+		}
+		return
+	}
+
+	manual := ab.adjusted && !ab.paused && !between(state.Color, ab.from, ab.to)
+	adaptiveByLabelMu.Unlock()
+
+	if manual {
+		pauseAdaptive(label)
+	}
+}
+
+// between returns whether a color could be part of a transition between two colors, give or take rounding.
+func between(color, from, to lifx.HSBK) bool {
+	within := func(v, a, b, slack int) bool {
+		if a > b {
+			a, b = b, a
+		}
+		return a-slack <= v && v <= b+slack
+	}
+	return color.Saturation <= 1 &&
+		within(color.Kelvin, from.Kelvin, to.Kelvin, 50) &&
+		within(color.Brightness, from.Brightness, to.Brightness, 2)
+}
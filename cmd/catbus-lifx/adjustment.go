@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// adjustment is a change to a value, either absolute, e.g. "50", or relative, e.g. "+10", "-25", "*0.5", or "toggle".
+type adjustment struct {
+	operator byte
+	operand  float64
+}
+
+const (
+	absolute = byte(0)
+	toggle   = byte('t')
+)
+
+func parseAdjustment(raw string) (adjustment, error) {
+	if raw == "toggle" {
+		return adjustment{operator: toggle}, nil
+	}
+
+	a := adjustment{operator: absolute}
+	if len(raw) > 0 && (raw[0] == '+' || raw[0] == '-' || raw[0] == '*') {
+		a.operator = raw[0]
+		raw = raw[1:]
+	}
+
+	operand, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(operand) || math.IsInf(operand, 0) || (a.operator != absolute && operand < 0) {
+		return adjustment{}, fmt.Errorf("must be a number, +N, -N, *N, or toggle, found %q", raw)
+	}
+	a.operand = operand
+	return a, nil
+}
+
+// clamp applies the adjustment to a value, clamping it within [min,max].
+// Toggling flips the value to whichever of min and max is further away.
+func (a adjustment) clamp(value, min, max int) int {
+	if a.operator == toggle {
+		if value-min < max-value {
+			return max
+		}
+		return min
+	}
+
+	v := a.apply(value)
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// wrap applies the adjustment to a value, wrapping it around within [min,max], e.g. for hue.
+// Toggling moves the value halfway around.
+func (a adjustment) wrap(value, min, max int) int {
+	size := max - min + 1
+
+	v := value + size/2
+	if a.operator != toggle {
+		v = a.apply(value)
+	}
+
+	v = (v - min) % size
+	if v < 0 {
+		v += size
+	}
+	return v + min
+}
+
+func (a adjustment) apply(value int) int {
+	switch a.operator {
+	case '+':
+		return int(math.Round(float64(value) + a.operand))
+	case '-':
+		return int(math.Round(float64(value) - a.operand))
+	case '*':
+		return int(math.Round(float64(value) * a.operand))
+	default:
+		return int(a.operand)
+	}
+}
+
+// setTo returns an absolute adjustment.
+func setTo(value int) adjustment {
+	return adjustment{operator: absolute, operand: float64(value)}
+}
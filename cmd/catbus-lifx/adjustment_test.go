@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseAdjustment(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want adjustment
+	}{
+		{raw: "50", want: adjustment{operator: absolute, operand: 50}},
+		{raw: "-5", want: adjustment{operator: '-', operand: 5}},
+		{raw: "+10", want: adjustment{operator: '+', operand: 10}},
+		{raw: "*0.5", want: adjustment{operator: '*', operand: 0.5}},
+		{raw: "toggle", want: adjustment{operator: toggle}},
+	}
+	for _, tt := range tests {
+		got, err := parseAdjustment(tt.raw)
+		if err != nil {
+			t.Errorf("parseAdjustment(%q): %v", tt.raw, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseAdjustment(%q) = %+v, want %+v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestParseAdjustmentInvalid(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"+",
+		"on",
+		"Toggle",
+		"+-5",
+		"*-1",
+		"NaN",
+		"+Inf",
+	} {
+		if _, err := parseAdjustment(raw); err == nil {
+			t.Errorf("parseAdjustment(%q) = nil error, want an error", raw)
+		}
+	}
+}
+
+func TestAdjustmentClamp(t *testing.T) {
+	tests := []struct {
+		raw   string
+		value int
+		want  int
+	}{
+		{raw: "50", value: 20, want: 50},
+		{raw: "150", value: 20, want: 100},
+		{raw: "+10", value: 20, want: 30},
+		{raw: "+10", value: 95, want: 100},
+		{raw: "-25", value: 20, want: 0},
+		{raw: "*0.5", value: 25, want: 13},
+		{raw: "*2", value: 80, want: 100},
+		{raw: "toggle", value: 20, want: 100},
+		{raw: "toggle", value: 80, want: 0},
+	}
+	for _, tt := range tests {
+		a, err := parseAdjustment(tt.raw)
+		if err != nil {
+			t.Fatalf("parseAdjustment(%q): %v", tt.raw, err)
+		}
+		if got := a.clamp(tt.value, 0, 100); got != tt.want {
+			t.Errorf("%q.clamp(%d, 0, 100) = %d, want %d", tt.raw, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAdjustmentWrap(t *testing.T) {
+	tests := []struct {
+		raw   string
+		value int
+		want  int
+	}{
+		{raw: "120", value: 0, want: 120},
+		{raw: "360", value: 0, want: 0},
+		{raw: "+30", value: 350, want: 20},
+		{raw: "-30", value: 10, want: 340},
+		{raw: "+720", value: 10, want: 10},
+		{raw: "toggle", value: 0, want: 180},
+		{raw: "toggle", value: 270, want: 90},
+	}
+	for _, tt := range tests {
+		a, err := parseAdjustment(tt.raw)
+		if err != nil {
+			t.Fatalf("parseAdjustment(%q): %v", tt.raw, err)
+		}
+		if got := a.wrap(tt.value, 0, 359); got != tt.want {
+			t.Errorf("%q.wrap(%d, 0, 359) = %d, want %d", tt.raw, tt.value, got, tt.want)
+		}
+	}
+}
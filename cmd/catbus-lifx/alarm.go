@@ -0,0 +1,250 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.eth.moe/catbus"
+	"go.eth.moe/catbus-lifx/config"
+	"go.eth.moe/catbus-lifx/effects"
+	"go.eth.moe/catbus-lifx/lifx"
+	"go.eth.moe/logger"
+)
+
+type (
+	// alarmStage is part of a wake-up ramp, fading to a color over a fraction of the alarm's duration.
+	alarmStage struct {
+		color    lifx.HSBK
+		fraction float64
+	}
+)
+
+// alarmStages fade from deep red, through amber, to full warm white, like a sunrise.
+var alarmStages = []alarmStage{
+	{color: lifx.HSBK{Hue: 0, Saturation: 100, Brightness: 10, Kelvin: lifx.MinKelvin}, fraction: 0.4},
+	{color: lifx.HSBK{Hue: 30, Saturation: 90, Brightness: 50, Kelvin: lifx.MinKelvin}, fraction: 0.3},
+	{color: lifx.HSBK{Hue: 30, Saturation: 0, Brightness: 100, Kelvin: 2700}, fraction: 0.3},
+}
+
+var (
+	// pendingAlarmsByName cancel each alarm's next ramp.
+	pendingAlarmsByName   = map[string]pendingAlarm{}
+	pendingAlarmsByNameMu sync.Mutex
+)
+
+type pendingAlarm struct {
+	end    time.Time
+	cancel context.CancelFunc
+	// cancelled alarms are kept until their time, so that their scheduled time is skipped.
+	cancelled bool
+}
+
+// scheduleAlarms sets each scheduled alarm for its next time whenever it is not already set, forever.
+// An alarm set or cancelled through its topic replaces its next scheduled time.
+func scheduleAlarms(broker catbus.Client, c *config.Config) {
+	for _, alarm := range c.AlarmsByName {
+		if alarm.Schedule == nil {
+			continue
+		}
+		alarm := alarm
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for {
+				pendingAlarmsByNameMu.Lock()
+				pending, ok := pendingAlarmsByName[alarm.Name]
+				pendingAlarmsByNameMu.Unlock()
+
+				if !ok || !pending.end.After(time.Now()) {
+					setAlarm(broker, alarm, alarm.Schedule.Next(time.Now()))
+				}
+				<-ticker.C
+			}
+		}()
+	}
+}
+
+// setAlarmTime sets an alarm to finish at a time, e.g. "07:30" or RFC 3339, or cancels it with "none".
+func setAlarmTime(alarm config.Alarm) catbus.MessageHandler {
+	return func(broker catbus.Client, msg catbus.Message) {
+		log := logger.Background()
+		log.AddField("alarm", alarm.Name)
+		log.AddField("payload", msg.Payload)
+
+		if msg.Payload == "" || msg.Payload == effects.None {
+			if cancelAlarm(alarm.Name) {
+				log.Info("cancelled alarm")
+			}
+			return
+		}
+
+		end, err := parseAlarmTime(msg.Payload, time.Now())
+		if err != nil {
+			log.WithError(err).Warning("invalid alarm time")
+			return
+		}
+		if !end.After(time.Now()) {
+			// e.g. a retained time from yesterday.
+			return
+		}
+		setAlarm(broker, alarm, end)
+	}
+}
+
+// parseAlarmTime parses RFC 3339, or "15:04" as the next time it is that time.
+func parseAlarmTime(raw string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("15:04", raw)
+	if err != nil {
+		return time.Time{}, err
+	}
+	schedule := &config.AlarmSchedule{
+		Hour:   t.Hour(),
+		Minute: t.Minute(),
+		Days:   []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+	}
+	return schedule.Next(now), nil
+}
+
+// setAlarm sets an alarm to ramp its bulbs up, finishing at a given time, replacing any time it was already set for.
+func setAlarm(broker catbus.Client, alarm config.Alarm, end time.Time) {
+	log := logger.Background()
+	log.AddField("alarm", alarm.Name)
+	log.AddField("end", end.Format(time.RFC3339))
+
+	pendingAlarmsByNameMu.Lock()
+	if pending, ok := pendingAlarmsByName[alarm.Name]; ok {
+		if pending.end.Equal(end) && !pending.cancelled {
+			pendingAlarmsByNameMu.Unlock()
+			return
+		}
+		pending.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pendingAlarmsByName[alarm.Name] = pendingAlarm{end: end, cancel: cancel}
+	pendingAlarmsByNameMu.Unlock()
+
+	go func() {
+		defer cancelAlarmIf(alarm.Name, end)
+
+		// If the alarm was set late, the ramp is squashed into the time that is left.
+		duration := alarm.Duration
+		if remaining := time.Until(end); remaining < duration {
+			duration = remaining
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(end.Add(-duration))):
+		}
+
+		log.Info("starting alarm")
+		var wg sync.WaitGroup
+		for _, label := range alarm.Labels {
+			label := label
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				wake(ctx, broker, label, duration)
+			}()
+		}
+		wg.Wait()
+	}()
+	log.Info("set alarm")
+}
+
+// cancelAlarm cancels an alarm's pending or running ramp, returning whether there was one.
+func cancelAlarm(name string) bool {
+	pendingAlarmsByNameMu.Lock()
+	defer pendingAlarmsByNameMu.Unlock()
+	pending, ok := pendingAlarmsByName[name]
+	if !ok || pending.cancelled {
+		return false
+	}
+	pending.cancel()
+	pending.cancelled = true
+	pendingAlarmsByName[name] = pending
+	return true
+}
+
+// cancelAlarmIf forgets an alarm if it is still set for a given time, i.e. once its ramp is finished.
+func cancelAlarmIf(name string, end time.Time) {
+	pendingAlarmsByNameMu.Lock()
+	defer pendingAlarmsByNameMu.Unlock()
+	if pending, ok := pendingAlarmsByName[name]; ok && pending.end.Equal(end) {
+		pending.cancel()
+		delete(pendingAlarmsByName, name)
+	}
+}
+
+// wake ramps a bulb from off through each stage, over a duration.
+// Like an effect, it is cancelled by any conflicting command to the bulb.
+func wake(ctx context.Context, broker catbus.Client, label string, duration time.Duration) {
+	log := logger.Background()
+	log.AddField("bulb", label)
+
+	bulb, ok := devices.bulb(label)
+	if !ok {
+		log.Error("could not find bulb")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopEffect(label, false)
+	cancelEffects(broker, label)
+	effectsByLabelMu.Lock()
+	effectsByLabel[label] = runningEffect{payload: "alarm", stop: func(bool) { cancel() }}
+	effectsByLabelMu.Unlock()
+	defer func() {
+		effectsByLabelMu.Lock()
+		defer effectsByLabelMu.Unlock()
+		if running, ok := effectsByLabel[label]; ok && running.payload == "alarm" {
+			delete(effectsByLabel, label)
+		}
+	}()
+
+	// Start at the first stage's color, but with no brightness, so that turning on is invisible.
+	start := alarmStages[0].color
+	start.Brightness = lifx.MinBrightness
+	setCtx, setCancel := context.WithTimeout(ctx, 5*time.Second)
+	err := bulb.SetColor(setCtx, start, 0)
+	if err == nil {
+		err = bulb.SetPower(setCtx, lifx.On, 0)
+	}
+	setCancel()
+	if err != nil {
+		reportAvailability(broker, label, err)
+		log.WithError(err).Error("could not start alarm")
+		return
+	}
+
+	for _, stage := range alarmStages {
+		d := time.Duration(float64(duration) * stage.fraction)
+
+		setCtx, setCancel := context.WithTimeout(ctx, 5*time.Second)
+		err := bulb.SetColor(setCtx, stage.color, d)
+		setCancel()
+		if err != nil {
+			reportAvailability(broker, label, err)
+			log.WithError(err).Error("could not set alarm stage")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("cancelled alarm")
+			return
+		case <-time.After(d):
+		}
+	}
+	devices.setState(label, lifx.State{Label: label, Power: lifx.On, Color: alarmStages[len(alarmStages)-1].color})
+	log.Info("finished alarm")
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"go.eth.moe/catbus"
+	"go.eth.moe/catbus-lifx/config"
+	"go.eth.moe/catbus-lifx/lifx"
+	"go.eth.moe/logger"
+)
+
+// subscribedAutoLabels are the auto-configured bulbs whose commands have been subscribed to, and is only used by the discovery loop.
+var subscribedAutoLabels = map[string]bool{}
+
+// autoConfigure adds discovered bulbs that have no config to the registry, with topics from the topic template, and prepares the actuator for them.
+// Besides the template's own variables, it has those of config.DiscoveredVariables, and bulbs not in a group fall back to the template's default group, if it has one.
+func autoConfigure(c *config.Config) func(string, lifx.Info) bool {
+	return func(label string, info lifx.Info) bool {
+		log := logger.Background()
+		log.AddField("bulb", label)
+
+		bulb, err := c.AutoBulb(label, config.DiscoveredVariables(info))
+		if err != nil {
+			log.WithError(err).Warning("could not auto-configure bulb")
+			return false
+		}
+		if other, ok := devices.add(bulb); !ok {
+			log.AddField("other-bulb", other)
+			log.Warning("could not auto-configure bulb, as its topics would be shared with another bulb")
+			return false
+		}
+
+		addBulb(c, bulb)
+		log.Info("auto-configured bulb")
+		return true
+	}
+}
+
+// syncAutoConfigured subscribes to the commands for newly auto-configured bulbs, and forgets those that have missed several discovery passes.
+func syncAutoConfigured(broker catbus.Client, c *config.Config, actuates bool) {
+	for _, d := range devices.devices() {
+		if !d.auto {
+			continue
+		}
+		log := logger.Background()
+		log.AddField("bulb", d.label)
+
+		if d.missed >= missedDiscoveriesBeforeRemoval {
+			if subscribedAutoLabels[d.label] {
+				unsubscribeBulb(broker, c, d.config)
+				delete(subscribedAutoLabels, d.label)
+			}
+			removeBulb(d.label)
+			devices.remove(d.label)
+			log.Info("forgot missing auto-configured bulb")
+			continue
+		}
+
+		if actuates && !subscribedAutoLabels[d.label] {
+			subscribeBulb(broker, c, d.config)
+			subscribedAutoLabels[d.label] = true
+			log.Info("subscribed to auto-configured bulb")
+		}
+	}
+}
+
+// sharesTopic returns whether two bulbs have any topic in common.
+func sharesTopic(a, b config.Topics) bool {
+	topics := map[string]bool{}
+	for _, t := range []string{a.Power, a.Hue, a.Saturation, a.Brightness, a.Kelvin, a.Availability, a.Effect} {
+		if t != "" {
+			topics[t] = true
+		}
+	}
+	for _, t := range []string{b.Power, b.Hue, b.Saturation, b.Brightness, b.Kelvin, b.Availability, b.Effect} {
+		if topics[t] {
+			return true
+		}
+	}
+	return false
+}
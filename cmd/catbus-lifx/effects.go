@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.eth.moe/catbus"
+	"go.eth.moe/catbus-lifx/effects"
+	"go.eth.moe/catbus-lifx/lifx"
+	"go.eth.moe/logger"
+)
+
+type (
+	// runningEffect is a software effect running on a bulb or group.
+	runningEffect struct {
+		payload string
+		stop    func(restore bool)
+	}
+)
+
+var (
+	// effectTopicsByLabel are where to publish each bulb's effect, if anywhere.
+	effectTopicsByLabel = map[string]string{}
+
+	effectsByLabel   = map[string]runningEffect{}
+	effectsByLabelMu sync.Mutex
+)
+
+// setEffect starts or stops a software effect on a bulb.
+// Effects on a group run on its bulbs with their phases offset, e.g. to spread a color cycle across a room.
+func setEffect(label string) catbus.MessageHandler {
+	return func(broker catbus.Client, msg catbus.Message) {
+		log, ctx := logger.FromContext(context.Background())
+		log.AddField("bulb", label)
+		log.AddField("payload", msg.Payload)
+
+		// e.g. the "none" published when an effect was cancelled, which would otherwise stop an effect started since.
+		if isEcho(msg) {
+			return
+		}
+		if msg.Payload == "" || msg.Payload == effects.None {
+			if stopEffect(label, true) {
+				log.Info("stopped effect")
+			}
+			return
+		}
+
+		effectsByLabelMu.Lock()
+		running, ok := effectsByLabel[label]
+		effectsByLabelMu.Unlock()
+		if ok && running.payload == msg.Payload {
+			return
+		}
+
+		effect, period, err := effects.Parse(msg.Payload)
+		if err != nil {
+			log.WithError(err).Warning("invalid effect")
+			return
+		}
+
+		d, ok := devices.device(label)
+		if !ok || d.bulb == nil {
+			log.Error("could not find bulb")
+			return
+		}
+		bulbs := []lifx.Bulb{d.bulb}
+		if len(d.members) > 0 {
+			bulbs = nil
+			for _, member := range d.members {
+				if bulb, ok := devices.bulb(member); ok {
+					bulbs = append(bulbs, bulb)
+				}
+			}
+		}
+
+		// Effects conflict with each other just as they do with commands.
+		// The bulb's own effect is replaced rather than cancelled, so it is stopped first.
+		stopEffect(label, true)
+		cancelEffects(broker, label)
+
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		stop, err := effects.Start(ctx, effect, period, bulbs...)
+		if err != nil {
+			reportAvailability(broker, label, err)
+			log.WithError(err).Error("could not start effect")
+			return
+		}
+
+		effectsByLabelMu.Lock()
+		effectsByLabel[label] = runningEffect{payload: msg.Payload, stop: stop}
+		effectsByLabelMu.Unlock()
+
+		if topic, ok := effectTopic(label); ok {
+			if err := publishChanged(broker, topic, msg.Payload); err != nil {
+				log.WithError(err).Error("could not publish effect")
+			}
+		}
+		log.Info("started effect")
+	}
+}
+
+func effectTopic(label string) (string, bool) {
+	bulbsMu.Lock()
+	defer bulbsMu.Unlock()
+	topic, ok := effectTopicsByLabel[label]
+	return topic, ok
+}
+
+// stopEffect stops the effect on a bulb, if any, returning whether there was one.
+func stopEffect(label string, restore bool) bool {
+	effectsByLabelMu.Lock()
+	running, ok := effectsByLabel[label]
+	delete(effectsByLabel, label)
+	effectsByLabelMu.Unlock()
+
+	if ok {
+		running.stop(restore)
+	}
+	return ok
+}
+
+// cancelEffects stops any effects that conflict with a command to a bulb, i.e. on the bulb, its groups, or its members, and publishes that they stopped.
+func cancelEffects(broker catbus.Client, label string) {
+	for _, l := range devices.related(label) {
+		if !stopEffect(l, false) {
+			continue
+		}
+
+		log := logger.Background()
+		log.AddField("bulb", l)
+		log.Info("cancelled effect")
+
+		if topic, ok := effectTopic(l); ok {
+			if err := publishChanged(broker, topic, effects.None); err != nil {
+				log.WithError(err).Error("could not publish effect")
+			}
+		}
+	}
+}
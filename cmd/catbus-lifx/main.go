@@ -0,0 +1,278 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+// Binary catbus-lifx observes and controls Lifx bulbs via Catbus.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"go.eth.moe/catbus"
+	"go.eth.moe/catbus-lifx/config"
+	"go.eth.moe/catbus-lifx/homie"
+	"go.eth.moe/catbus-lifx/lifx"
+	"go.eth.moe/catbus-lifx/mqtt"
+	"go.eth.moe/flag"
+	"go.eth.moe/logger"
+)
+
+// runMode is which halves of the bridge to run.
+type runMode string
+
+const (
+	modeBoth    = runMode("both")
+	modeObserve = runMode("observe")
+	modeActuate = runMode("actuate")
+)
+
+var (
+	configPath = flag.Custom("config-path", "", "path to the config file, in JSON, YAML, or TOML", flag.RequiredString)
+	mode       = flag.Custom("mode", string(modeBoth), "observe (publish bulb states), actuate (apply commands), or both", parseMode)
+
+	checkConfig = flag.Custom("check-config", "false", "only check the config, listing any problems, and exit", parseBool)
+	dumpTopics  = flag.Custom("dump-topics", "false", "only print each bulb's topics as JSON, after expanding the topic template, and exit", parseBool)
+)
+
+// devices is the shared model of all configured bulbs.
+var devices *registry
+
+func main() {
+	flag.Parse()
+
+	configPath := (*configPath).(string)
+	mode := (*mode).(runMode)
+	checkConfig := (*checkConfig).(bool)
+	dumpTopics := (*dumpTopics).(bool)
+
+	log := logger.Background()
+
+	config, err := config.ParseFile(configPath)
+	if checkConfig {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v is invalid:\n%v\n", configPath, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%v is valid\n", configPath)
+		return
+	}
+	if err != nil {
+		log.AddField("config-path", configPath)
+		log.WithError(err).Fatal("could not load config")
+	}
+	if dumpTopics {
+		printTopics(config)
+		return
+	}
+
+	devices = newRegistry(config)
+	adaptiveConfig = config.Adaptive
+	for label, bulb := range config.BulbsByLabel {
+		addBulb(config, bulb)
+		setAdaptive(label, bulb.Adaptive)
+	}
+	setScenes(config)
+	if config.AutoConfigure {
+		devices.autoConfigure = autoConfigure(config)
+	}
+
+	var roles []string
+	if mode.observes() {
+		roles = append(roles, "observer")
+	}
+	if mode.actuates() {
+		roles = append(roles, "actuator")
+	}
+
+	broker := mqtt.NewClient(config.BrokerURI, mqtt.ClientOptions{
+		ClientID:  config.MQTT.ClientID,
+		KeepAlive: config.MQTT.KeepAlive,
+		Username:  config.MQTT.Username,
+		Password:  config.MQTT.Password,
+		TLS:       config.MQTT.TLS,
+		Wills:     wills(config, roles),
+
+		ConnectHandler: func(broker catbus.Client) {
+			log := logger.Background()
+			log.AddField("broker-uri", config.BrokerURI)
+			log.Info("connected to MQTT broker")
+
+			forgetPublished()
+
+			if !mode.actuates() {
+				return
+			}
+
+			subscribeBulbs(broker, config)
+			log.Info("subscribed to all topics for all bulbs")
+
+			for _, alarm := range config.AlarmsByName {
+				if alarm.Topic == "" {
+					continue
+				}
+				if err := broker.Subscribe(alarm.Topic, setAlarmTime(alarm)); err != nil {
+					log := log.WithError(err)
+					log.AddField("topic", alarm.Topic)
+					log.Error("could not subscribe to alarm")
+				}
+			}
+
+			subscribeScenes(broker)
+
+			if config.HomeAssistant != nil {
+				topic := config.HomeAssistant.TopicPrefix + "/+/set"
+				if err := broker.Subscribe(topic, setHomeAssistant(config.HomeAssistant)); err != nil {
+					log := log.WithError(err)
+					log.AddField("topic", topic)
+					log.Error("could not subscribe to Home Assistant commands")
+				}
+			}
+		},
+		DisconnectHandler: func(_ catbus.Client, err error) {
+			log := logger.Background()
+			log.AddField("broker-uri", config.BrokerURI)
+			log.WithError(err).Error("disconnected from MQTT broker")
+		},
+	})
+
+	go disconnectOnExit(config, broker, mode, roles)
+
+	if mode.observes() {
+		// Publish changes from commands and from other clients immediately, rather than waiting for the next discovery pass.
+		devices.onChange = func(d device) {
+			publishBulbState(config, broker, d)
+		}
+		go func() {
+			if err := lifx.Listen(context.Background(), devices.applyUpdate); err != nil {
+				log.WithError(err).Error("could not listen for bulb updates")
+			}
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		reloads := watchConfig(configPath)
+		loaded := config
+		for first := true; ; first = false {
+			devices.discover(context.Background())
+			if mode.observes() {
+				publishBulbStates(config, broker)
+			}
+			if config.AutoConfigure {
+				syncAutoConfigured(broker, config, mode.actuates())
+			}
+			// Schedules that catch up on a missed time need to know the bulbs' states.
+			if first && len(config.Schedules) > 0 && mode.actuates() {
+				go runSchedules(context.Background(), broker, config)
+			}
+
+			// Discover again straight after a reload, to find any new bulbs.
+			select {
+			case <-ticker.C:
+			case next := <-reloads:
+				reloadConfig(broker, loaded, next, mode)
+				loaded = next
+			}
+		}
+	}()
+	if mode.observes() {
+		go devices.poll(context.Background())
+	}
+	if mode.actuates() {
+		scheduleAlarms(broker, config)
+	}
+	if config.Adaptive != nil && mode.actuates() {
+		go adapt(context.Background(), config)
+	}
+
+	log.AddField("broker-uri", config.BrokerURI)
+	log.Info("connecting to MQTT broker")
+	if err := broker.Connect(); err != nil {
+		log.WithError(err).Fatal("could not connect to MQTT broker")
+	}
+}
+
+func parseMode(raw string) (interface{}, error) {
+	switch m := runMode(raw); m {
+	case modeBoth, modeObserve, modeActuate:
+		return m, nil
+	default:
+		return nil, fmt.Errorf("mode must be %v, %v, or %v", modeBoth, modeObserve, modeActuate)
+	}
+}
+
+// printTopics prints the topics of every bulb, as they would appear in the config's "bulbs".
+func printTopics(c *config.Config) {
+	topicsByLabel := map[string]config.Topics{}
+	for label, bulb := range c.BulbsByLabel {
+		topicsByLabel[label] = bulb.Topics
+	}
+	bytes, err := json.MarshalIndent(topicsByLabel, "", "\t")
+	if err != nil {
+		panic(fmt.Sprintf("could not marshal topics: %v", err))
+	}
+	fmt.Println(string(bytes))
+}
+
+func parseBool(raw string) (interface{}, error) {
+	return strconv.ParseBool(raw)
+}
+
+func (m runMode) observes() bool {
+	return m == modeBoth || m == modeObserve
+}
+func (m runMode) actuates() bool {
+	return m == modeBoth || m == modeActuate
+}
+
+// disconnectOnExit marks the bridge as offline, and Homie devices as disconnected, when it is stopped.
+func disconnectOnExit(c *config.Config, broker catbus.Client, mode runMode, roles []string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	log := logger.Background()
+
+	if c.Layout == config.LayoutHomie && mode.observes() {
+		for _, d := range devices.devices() {
+			if !d.announced {
+				continue
+			}
+			stateTopic := homie.StateTopic(c.Homie.BaseTopic, homie.DeviceID(d.label))
+			if err := broker.Publish(stateTopic, catbus.Retain, homie.StateDisconnected); err != nil {
+				log := log.WithError(err)
+				log.AddField("bulb", d.label)
+				log.Error("could not mark Homie device as disconnected")
+			}
+		}
+	}
+
+	for _, role := range roles {
+		availability := c.BridgeAvailability(role)
+		if err := broker.Publish(availability.Topic, catbus.Retain, availability.Offline); err != nil {
+			log.WithError(err).Error("could not publish availability")
+		}
+	}
+	os.Exit(0)
+}
+
+// wills mark each role offline if the bridge goes without disconnecting, e.g. because it crashed, and online when it connects.
+func wills(c *config.Config, roles []string) []mqtt.Will {
+	var wills []mqtt.Will
+	for _, role := range roles {
+		availability := c.BridgeAvailability(role)
+		wills = append(wills, mqtt.Will{
+			Topic:     availability.Topic,
+			Lost:      availability.Offline,
+			Connected: availability.Online,
+		})
+	}
+	return wills
+}
@@ -0,0 +1,247 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"strconv"
+	"sync"
+
+	"go.eth.moe/catbus"
+	"go.eth.moe/catbus-lifx/config"
+	"go.eth.moe/catbus-lifx/homeassistant"
+	"go.eth.moe/catbus-lifx/homie"
+	"go.eth.moe/catbus-lifx/lifx"
+	"go.eth.moe/logger"
+)
+
+// missedDiscoveriesBeforeRemoval is how many discovery passes a bulb can miss before it is removed from Home Assistant.
+const missedDiscoveriesBeforeRemoval = 3
+
+var (
+	// publishedPayloads is the last payload published to each topic, so that only changes are published.
+	publishedPayloads   = map[string]string{}
+	publishedPayloadsMu sync.Mutex
+
+	// echoesByTopic are the payloads published to each topic that the broker has not yet sent back.
+	// In the catbus layout a bulb's state topics are also its command topics, so the bridge receives its own state, which is not a command.
+	// It has its own lock, as message handlers must not wait on a publish, which waits on the broker.
+	echoesByTopic   = map[string][]string{}
+	echoesByTopicMu sync.Mutex
+)
+
+// maxEchoes is how many echoes to expect on a topic, beyond which the oldest are assumed lost, e.g. for topics the bridge does not subscribe to.
+const maxEchoes = 8
+
+// publishChanged publishes a retained payload, unless it is already the last payload published to the topic.
+func publishChanged(broker catbus.Client, topic, payload string) error {
+	publishedPayloadsMu.Lock()
+	defer publishedPayloadsMu.Unlock()
+
+	if last, ok := publishedPayloads[topic]; ok && last == payload {
+		return nil
+	}
+	// The echo may arrive before Publish returns.
+	expectEcho(topic, payload)
+	if err := broker.Publish(topic, catbus.Retain, payload); err != nil {
+		return err
+	}
+	publishedPayloads[topic] = payload
+	return nil
+}
+
+func expectEcho(topic, payload string) {
+	echoesByTopicMu.Lock()
+	defer echoesByTopicMu.Unlock()
+	echoes := append(echoesByTopic[topic], payload)
+	if len(echoes) > maxEchoes {
+		echoes = echoes[len(echoes)-maxEchoes:]
+	}
+	echoesByTopic[topic] = echoes
+}
+
+// isEcho returns whether a message is the broker sending back the bridge's own publish, rather than a command.
+// Echoes arrive in the order they were published, so any expected before it were lost.
+func isEcho(msg catbus.Message) bool {
+	echoesByTopicMu.Lock()
+	defer echoesByTopicMu.Unlock()
+	echoes := echoesByTopic[msg.Topic]
+	for i, payload := range echoes {
+		if payload == msg.Payload {
+			echoesByTopic[msg.Topic] = echoes[i+1:]
+			return true
+		}
+	}
+	return false
+}
+
+// forgetPublished makes publishChanged publish everything again, e.g. in case the broker lost its retained messages.
+func forgetPublished() {
+	publishedPayloadsMu.Lock()
+	defer publishedPayloadsMu.Unlock()
+	publishedPayloads = map[string]string{}
+}
+
+// publishBulbStates publishes the cached state of every configured bulb.
+func publishBulbStates(c *config.Config, broker catbus.Client) {
+	for _, d := range devices.devices() {
+		publishBulbState(c, broker, d)
+	}
+}
+
+// publishBulbState publishes the cached state of a bulb, announcing it first if needed.
+func publishBulbState(c *config.Config, broker catbus.Client, d device) {
+	label := d.label
+
+	log := logger.Background()
+	log.AddField("bulb", label)
+
+	// Bulbs that were not discovered, or did not respond, are offline.
+	if d.bulb == nil || d.missed > 0 || d.unreachable {
+		if availability, ok := c.BulbAvailability(d.config); ok {
+			if err := publishChanged(broker, availability.Topic, availability.Offline); err != nil {
+				log.WithError(err).Error("could not publish availability")
+			}
+		}
+		if d.missed >= missedDiscoveriesBeforeRemoval {
+			removeMissingBulb(broker, d)
+		}
+		return
+	}
+
+	if c.Layout == config.LayoutHomie {
+		publishHomie(c.Homie, broker, d.state, !d.announced)
+	} else {
+		publishCatbus(d.config, broker, d.state)
+	}
+	if availability, ok := c.BulbAvailability(d.config); ok {
+		if err := publishChanged(broker, availability.Topic, availability.Online); err != nil {
+			log.WithError(err).Error("could not publish availability")
+		}
+	}
+
+	// Groups have no MAC address to identify them to Home Assistant.
+	configTopic := d.homeAssistantConfigTopic
+	if c.HomeAssistant != nil && d.info.MAC != nil {
+		topic, err := publishHomeAssistant(c, broker, d.config, d.info, d.state, configTopic == "")
+		if err != nil {
+			log.WithError(err).Error("could not publish to Home Assistant")
+		} else {
+			configTopic = topic
+		}
+	}
+
+	devices.update(label, func(d *device) {
+		d.announced = true
+		d.homeAssistantConfigTopic = configTopic
+	})
+}
+
+func publishCatbus(bulbConfig config.Bulb, broker catbus.Client, state lifx.State) {
+	log := logger.Background()
+	log.AddField("bulb", state.Label)
+
+	if err := publishChanged(broker, bulbConfig.Topics.Power, state.Power.String()); err != nil {
+		log.WithError(err).Error("could not publish power")
+	}
+	if err := publishChanged(broker, bulbConfig.Topics.Hue, strconv.Itoa(state.Color.Hue)); err != nil {
+		log.WithError(err).Error("could not publish hue")
+	}
+	if err := publishChanged(broker, bulbConfig.Topics.Saturation, strconv.Itoa(state.Color.Saturation)); err != nil {
+		log.WithError(err).Error("could not publish saturation")
+	}
+	if err := publishChanged(broker, bulbConfig.Topics.Brightness, strconv.Itoa(state.Color.Brightness)); err != nil {
+		log.WithError(err).Error("could not publish brightness")
+	}
+	if err := publishChanged(broker, bulbConfig.Topics.Kelvin, strconv.Itoa(state.Color.Kelvin)); err != nil {
+		log.WithError(err).Error("could not publish kelvin")
+	}
+	log.Info("published bulb status")
+}
+
+// publishHomie publishes a bulb's property values, and if it is newly announced, its attributes.
+// The device's $state is its availability.
+func publishHomie(h config.Homie, broker catbus.Client, state lifx.State, isNew bool) {
+	log := logger.Background()
+	log.AddField("bulb", state.Label)
+
+	deviceID := homie.DeviceID(state.Label)
+	stateTopic := homie.StateTopic(h.BaseTopic, deviceID)
+
+	var messages []homie.Message
+	if isNew {
+		messages = append(messages, homie.Message{Topic: stateTopic, Payload: homie.StateInit})
+		messages = append(messages, homie.DeviceAttributes(h.BaseTopic, deviceID, state.Label)...)
+	}
+	messages = append(messages, homie.PropertyValues(h.BaseTopic, deviceID, state)...)
+
+	for _, m := range messages {
+		if err := publishChanged(broker, m.Topic, m.Payload); err != nil {
+			log := log.WithError(err)
+			log.AddField("topic", m.Topic)
+			log.Error("could not publish Homie message")
+		}
+	}
+	log.Info("published bulb status")
+}
+
+// publishHomeAssistant publishes a bulb's state, and if announce is set its discovery document, returning the discovery document's topic.
+func publishHomeAssistant(c *config.Config, broker catbus.Client, bulb config.Bulb, info lifx.Info, state lifx.State, announce bool) (string, error) {
+	ha := c.HomeAssistant
+	configTopic := homeassistant.ConfigTopic(ha.DiscoveryPrefix, info)
+
+	stateTopic := homeassistant.StateTopic(ha.TopicPrefix, info)
+	if err := publishChanged(broker, stateTopic, homeassistant.Marshal(homeassistant.NewState(state))); err != nil {
+		return "", err
+	}
+	if !announce {
+		return configTopic, nil
+	}
+
+	availabilities := []config.Availability{
+		c.BridgeAvailability("observer"),
+		c.BridgeAvailability("actuator"),
+	}
+	if a, ok := c.BulbAvailability(bulb); ok {
+		availabilities = append(availabilities, a)
+	}
+	var availability []homeassistant.Availability
+	for _, a := range availabilities {
+		availability = append(availability, homeassistant.Availability{
+			Topic:               a.Topic,
+			PayloadAvailable:    a.Online,
+			PayloadNotAvailable: a.Offline,
+		})
+	}
+
+	discovery := homeassistant.NewDiscovery(ha.TopicPrefix, state.Label, info, availability)
+	if err := publishChanged(broker, configTopic, homeassistant.Marshal(discovery)); err != nil {
+		return "", err
+	}
+	return configTopic, nil
+}
+
+// removeMissingBulb removes a bulb from Home Assistant once it has not been seen for several discovery passes.
+// If it is rediscovered, it will be announced again.
+func removeMissingBulb(broker catbus.Client, d device) {
+	if !d.announced {
+		return
+	}
+
+	log := logger.Background()
+	log.AddField("bulb", d.label)
+
+	if d.homeAssistantConfigTopic != "" {
+		// An empty retained message removes the light from Home Assistant.
+		if err := publishChanged(broker, d.homeAssistantConfigTopic, ""); err != nil {
+			log.WithError(err).Error("could not remove bulb from Home Assistant")
+			return
+		}
+	}
+	devices.update(d.label, func(d *device) {
+		d.announced = false
+		d.homeAssistantConfigTopic = ""
+	})
+	log.Info("removed missing bulb")
+}
@@ -0,0 +1,362 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.eth.moe/catbus"
+	"go.eth.moe/catbus-lifx/lifx"
+	"go.eth.moe/logger"
+)
+
+// field is a property of a bulb that commands change.
+type field string
+
+const (
+	fieldPower      = field("power")
+	fieldHue        = field("hue")
+	fieldSaturation = field("saturation")
+	fieldBrightness = field("brightness")
+	fieldKelvin     = field("kelvin")
+)
+
+type (
+	// command is a change to some of a bulb's fields.
+	command struct {
+		// adjustmentsByField are applied in order.
+		// Power is 0 for off and 1 for on.
+		adjustmentsByField map[field][]adjustment
+
+		// colorTransition and powerTransition are how long to smooth changes over.
+		colorTransition time.Duration
+		powerTransition time.Duration
+	}
+
+	// pipeline applies commands to a bulb one at a time, in the order they arrived.
+	// Commands that arrive within its window of each other are collapsed into one, keeping only the latest value of each field.
+	pipeline struct {
+		label string
+
+		mu      sync.Mutex
+		window  time.Duration
+		broker  catbus.Client
+		pending *command
+
+		// ready is signalled when pending goes from nil to a command.
+		ready chan struct{}
+	}
+)
+
+func newPipeline(label string, window time.Duration) *pipeline {
+	p := &pipeline{
+		label:  label,
+		window: window,
+		ready:  make(chan struct{}, 1),
+	}
+	go p.run()
+	return p
+}
+
+// send queues a command to be applied after the window, collapsing it with any command already queued.
+// Any conflicting effects are stopped first.
+func (p *pipeline) send(broker catbus.Client, c command) {
+	cancelEffects(broker, p.label)
+	if c.changesColor() {
+		for _, label := range devices.related(p.label) {
+			pauseAdaptive(label)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.broker = broker
+	if p.pending != nil {
+		p.pending.merge(c)
+		return
+	}
+	p.pending = &c
+	p.ready <- struct{}{}
+}
+
+// setWindow changes the window, from the next command.
+func (p *pipeline) setWindow(window time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.window = window
+}
+
+func (p *pipeline) run() {
+	for range p.ready {
+		p.mu.Lock()
+		window := p.window
+		p.mu.Unlock()
+		time.Sleep(window)
+
+		p.mu.Lock()
+		broker, c := p.broker, *p.pending
+		p.pending = nil
+		p.mu.Unlock()
+
+		p.apply(broker, c)
+	}
+}
+
+// apply reads the state of the bulb, applies a command to it, and sets whatever changed.
+func (p *pipeline) apply(broker catbus.Client, c command) {
+	log, ctx := logger.FromContext(context.Background())
+	log.AddField("bulb", p.label)
+
+	d, ok := devices.device(p.label)
+	if !ok || d.bulb == nil {
+		log.Error("could not find bulb")
+		return
+	}
+	bulb := d.bulb
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if len(d.members) > 0 {
+		p.applyGroup(ctx, broker, c, bulb, d.members)
+		return
+	}
+
+	state, err := bulb.State(ctx)
+	if err != nil {
+		reportAvailability(broker, p.label, err)
+		log.WithError(err).Error("could not get bulb state")
+		return
+	}
+
+	color := c.color(state.Color)
+	power := c.power(state.Power)
+
+	// If the bulb is turning on, change its color first so it does not flash the old color.
+	colorTransition := c.colorTransition
+	if power == lifx.On && state.Power == lifx.Off {
+		colorTransition = 0
+		if !c.changesColor() {
+			color, _ = turnOnAdaptive(p.label, color)
+		}
+	}
+	log.AddField("power", power.String())
+	log.AddField("hue", color.Hue)
+	log.AddField("saturation", color.Saturation)
+	log.AddField("brightness", color.Brightness)
+	log.AddField("kelvin", color.Kelvin)
+
+	if color != state.Color {
+		if err := bulb.SetColor(ctx, color, colorTransition); err != nil {
+			reportAvailability(broker, p.label, err)
+			log.WithError(err).Error("could not set color")
+			return
+		}
+		state.Color = color
+	}
+	if power != state.Power {
+		if err := bulb.SetPower(ctx, power, c.powerTransition); err != nil {
+			reportAvailability(broker, p.label, err)
+			log.WithError(err).Error("could not set power")
+			return
+		}
+		state.Power = power
+	}
+	devices.setState(p.label, state)
+	reportAvailability(broker, p.label, nil)
+	log.Info("set bulb")
+}
+
+// applyGroup applies a command to each member of a group from the member's own state, so that e.g. raising the brightness raises each member's, rather than setting them all to their average.
+// Changes that are the same for every member are sent to the whole group together.
+func (p *pipeline) applyGroup(ctx context.Context, broker catbus.Client, c command, group lifx.Bulb, labels []string) {
+	log, _ := logger.FromContext(ctx)
+
+	type member struct {
+		label string
+		bulb  lifx.Bulb
+		state lifx.State
+		color lifx.HSBK
+	}
+	var members []*member
+	for _, label := range labels {
+		if bulb, ok := devices.bulb(label); ok {
+			members = append(members, &member{label: label, bulb: bulb})
+		}
+	}
+	var responded []*member
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, m := range members {
+		m := m
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state, err := m.bulb.State(ctx)
+			if err != nil {
+				log := log.WithError(err)
+				log.AddField("member", m.label)
+				log.Warning("could not get state of group member")
+				return
+			}
+			m.state = state
+
+			mu.Lock()
+			defer mu.Unlock()
+			responded = append(responded, m)
+		}()
+	}
+	wg.Wait()
+	if len(responded) == 0 {
+		reportAvailability(broker, p.label, lifx.ErrNoResponse)
+		log.Error("could not get state of any group member")
+		return
+	}
+
+	// The group is on if any member is on, so toggling it turns every member off.
+	groupPower := lifx.Off
+	for _, m := range responded {
+		if m.state.Power == lifx.On {
+			groupPower = lifx.On
+		}
+	}
+	power := c.power(groupPower)
+	_, setsPower := c.adjustmentsByField[fieldPower]
+
+	// As with a single bulb, members that are turning on change color first so they do not flash the old color.
+	colorTransition := c.colorTransition
+	same, changed := true, false
+	for _, m := range responded {
+		m.color = c.color(m.state.Color)
+		if setsPower && power == lifx.On && m.state.Power == lifx.Off {
+			colorTransition = 0
+			if !c.changesColor() {
+				m.color, _ = turnOnAdaptive(p.label, m.color)
+			}
+		}
+		same = same && m.color == responded[0].color
+		changed = changed || m.color != m.state.Color
+	}
+	log.AddField("power", power.String())
+
+	var err error
+	if same && len(responded) == len(labels) {
+		if changed {
+			err = group.SetColor(ctx, responded[0].color, colorTransition)
+		}
+	} else {
+		var errMu sync.Mutex
+		var wg sync.WaitGroup
+		for _, m := range responded {
+			if m.color == m.state.Color {
+				continue
+			}
+			m := m
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if e := m.bulb.SetColor(ctx, m.color, colorTransition); e != nil {
+					errMu.Lock()
+					err = e
+					errMu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	if err != nil {
+		reportAvailability(broker, p.label, err)
+		log.WithError(err).Error("could not set color")
+		return
+	}
+
+	// Power is always sent when it is commanded, as the group reads as on while any member is on.
+	if setsPower {
+		if err := group.SetPower(ctx, power, c.powerTransition); err != nil {
+			reportAvailability(broker, p.label, err)
+			log.WithError(err).Error("could not set power")
+			return
+		}
+	}
+
+	for _, m := range responded {
+		m.state.Color = m.color
+		if setsPower {
+			m.state.Power = power
+		}
+		devices.setState(m.label, m.state)
+	}
+	if state, err := group.State(ctx); err == nil {
+		devices.setState(p.label, state)
+	}
+	reportAvailability(broker, p.label, nil)
+	log.Info("set group")
+}
+
+// merge collapses a later command into c.
+// An absolute adjustment replaces any earlier adjustments to its field.
+func (c *command) merge(later command) {
+	if c.adjustmentsByField == nil {
+		c.adjustmentsByField = map[field][]adjustment{}
+	}
+	for f, adjustments := range later.adjustmentsByField {
+		for _, a := range adjustments {
+			if a.operator == absolute {
+				c.adjustmentsByField[f] = nil
+			}
+			c.adjustmentsByField[f] = append(c.adjustmentsByField[f], a)
+		}
+	}
+	c.colorTransition = later.colorTransition
+	c.powerTransition = later.powerTransition
+}
+
+// changesColor returns whether the command changes anything other than power.
+func (c command) changesColor() bool {
+	for f := range c.adjustmentsByField {
+		if f != fieldPower {
+			return true
+		}
+	}
+	return false
+}
+
+// color returns a color with the command's changes to hue, saturation, brightness, and kelvin.
+func (c command) color(color lifx.HSBK) lifx.HSBK {
+	color.Hue = c.wrap(fieldHue, color.Hue, lifx.MinHue, lifx.MaxHue)
+	color.Saturation = c.clamp(fieldSaturation, color.Saturation, lifx.MinSaturation, lifx.MaxSaturation)
+	color.Brightness = c.clamp(fieldBrightness, color.Brightness, lifx.MinBrightness, lifx.MaxBrightness)
+	color.Kelvin = c.clamp(fieldKelvin, color.Kelvin, lifx.MinKelvin, lifx.MaxKelvin)
+	return color
+}
+
+// power returns a power with the command's change to it.
+func (c command) power(power lifx.Power) lifx.Power {
+	on := 0
+	if power == lifx.On {
+		on = 1
+	}
+	if c.clamp(fieldPower, on, 0, 1) == 1 {
+		return lifx.On
+	}
+	return lifx.Off
+}
+
+func (c command) clamp(f field, value, min, max int) int {
+	for _, a := range c.adjustmentsByField[f] {
+		value = a.clamp(value, min, max)
+	}
+	return value
+}
+
+func (c command) wrap(f field, value, min, max int) int {
+	for _, a := range c.adjustmentsByField[f] {
+		value = a.wrap(value, min, max)
+	}
+	return value
+}
@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.eth.moe/catbus-lifx/lifx"
+)
+
+// commandOf returns a command with an adjustment to a field for each raw payload, as if each were sent to its topic in turn.
+func commandOf(t *testing.T, f field, raws ...string) command {
+	t.Helper()
+	var c command
+	for _, raw := range raws {
+		a, err := parseAdjustment(raw)
+		if err != nil {
+			t.Fatalf("parseAdjustment(%q): %v", raw, err)
+		}
+		c.merge(command{adjustmentsByField: map[field][]adjustment{f: {a}}})
+	}
+	return c
+}
+
+func TestCommandMerge(t *testing.T) {
+	start := lifx.HSBK{Hue: 350, Saturation: 50, Brightness: 50, Kelvin: 3500}
+
+	tests := []struct {
+		field field
+		raws  []string
+		want  lifx.HSBK
+	}{
+		{field: fieldBrightness, raws: []string{"+10", "+10"}, want: lifx.HSBK{Hue: 350, Saturation: 50, Brightness: 70, Kelvin: 3500}},
+		// Relative adjustments apply in the order they were sent.
+		{field: fieldBrightness, raws: []string{"+10", "*2"}, want: lifx.HSBK{Hue: 350, Saturation: 50, Brightness: 100, Kelvin: 3500}},
+		{field: fieldBrightness, raws: []string{"*2", "-50"}, want: lifx.HSBK{Hue: 350, Saturation: 50, Brightness: 50, Kelvin: 3500}},
+		// An absolute adjustment replaces those before it, but not those after it.
+		{field: fieldBrightness, raws: []string{"+10", "20", "+5"}, want: lifx.HSBK{Hue: 350, Saturation: 50, Brightness: 25, Kelvin: 3500}},
+		// Each toggle flips the value in turn, rather than cancelling out.
+		{field: fieldSaturation, raws: []string{"toggle", "toggle"}, want: lifx.HSBK{Hue: 350, Saturation: 100, Brightness: 50, Kelvin: 3500}},
+		{field: fieldHue, raws: []string{"+10", "+10"}, want: lifx.HSBK{Hue: 10, Saturation: 50, Brightness: 50, Kelvin: 3500}},
+		{field: fieldKelvin, raws: []string{"-1000", "-1000"}, want: lifx.HSBK{Hue: 350, Saturation: 50, Brightness: 50, Kelvin: 2500}},
+	}
+	for _, tt := range tests {
+		if got := commandOf(t, tt.field, tt.raws...).color(start); got != tt.want {
+			t.Errorf("%v %q: color = %+v, want %+v", tt.field, tt.raws, got, tt.want)
+		}
+	}
+}
+
+func TestCommandMergeFields(t *testing.T) {
+	c := commandOf(t, fieldPower, "toggle")
+	c.merge(command{
+		adjustmentsByField: map[field][]adjustment{fieldBrightness: {setTo(80)}},
+		colorTransition:    time.Second,
+	})
+
+	if got := c.power(lifx.Off); got != lifx.On {
+		t.Errorf("power = %v, want %v", got, lifx.On)
+	}
+	if got := c.color(lifx.HSBK{Brightness: 20, Kelvin: 3500}); got.Brightness != 80 {
+		t.Errorf("brightness = %v, want 80", got.Brightness)
+	}
+	if !c.changesColor() {
+		t.Errorf("changesColor = false, want true")
+	}
+	// The latest command's transitions win.
+	if c.colorTransition != time.Second {
+		t.Errorf("colorTransition = %v, want %v", c.colorTransition, time.Second)
+	}
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.eth.moe/logger"
+)
+
+const (
+	// activePollInterval is how often a bulb is polled just after a command or an external change.
+	activePollInterval = 2 * time.Second
+	// unreachablePollInterval is the longest an unreachable bulb goes between polls.
+	unreachablePollInterval = 5 * time.Minute
+)
+
+// poll polls each discovered bulb for its state when it is due, until the context is done.
+//
+// Bulbs are polled every activePollInterval after a change, backing off to their idlePollInterval while nothing changes, and further to unreachablePollInterval while they do not respond.
+func (r *registry) poll(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	polling := map[string]bool{}
+	done := make(chan string)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case label := <-done:
+			delete(polling, label)
+		case now := <-ticker.C:
+			for _, d := range r.devices() {
+				if d.bulb == nil || polling[d.label] || now.Before(d.nextPoll) {
+					continue
+				}
+				polling[d.label] = true
+
+				d := d
+				go func() {
+					r.pollDevice(ctx, d)
+					select {
+					case done <- d.label:
+					case <-ctx.Done():
+					}
+				}()
+			}
+		}
+	}
+}
+
+func (r *registry) pollDevice(ctx context.Context, d device) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	state, err := d.bulb.State(ctx)
+	if err != nil {
+		log := logger.Background()
+		log.AddField("bulb", d.label)
+		log.WithError(err).Warning("could not poll bulb state")
+
+		r.change(d.label, func(d *device) {
+			d.unreachable = true
+			d.backOff(unreachablePollInterval)
+		})
+		return
+	}
+
+	r.change(d.label, func(d *device) {
+		wasUnreachable := d.unreachable
+		d.unreachable = false
+
+		// Someone else changed the bulb, so keep a closer eye on it.
+		if state != d.state || wasUnreachable {
+			d.state = state
+			d.pollSoon()
+			return
+		}
+		d.backOff(d.idlePollInterval)
+	})
+}
+
+// pollSoon makes the bulb be polled every activePollInterval.
+func (d *device) pollSoon() {
+	d.pollInterval = activePollInterval
+	d.nextPoll = time.Now().Add(d.pollInterval)
+}
+
+// backOff doubles the time until the bulb is next polled, up to a limit.
+func (d *device) backOff(limit time.Duration) {
+	d.pollInterval *= 2
+	if d.pollInterval > limit {
+		d.pollInterval = limit
+	}
+	if d.pollInterval < activePollInterval {
+		d.pollInterval = activePollInterval
+	}
+	d.nextPoll = time.Now().Add(d.pollInterval)
+}
@@ -0,0 +1,395 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"go.eth.moe/catbus-lifx/config"
+	"go.eth.moe/catbus-lifx/homeassistant"
+	"go.eth.moe/catbus-lifx/lifx"
+	"go.eth.moe/logger"
+)
+
+type (
+	// device is the cached model of a configured bulb.
+	device struct {
+		label  string
+		config config.Bulb
+		// auto is whether the bulb was configured automatically when it was discovered, rather than by the config file.
+		auto bool
+		// members is the labels of the bulbs in a group, and is empty for real bulbs.
+		members []string
+
+		// bulb is nil until the bulb has been discovered.
+		bulb  lifx.Bulb
+		info  lifx.Info
+		state lifx.State
+
+		// missed is the number of discovery passes the bulb has missed since it was last seen.
+		missed int
+
+		// idlePollInterval is the longest the bulb goes between polls.
+		idlePollInterval time.Duration
+		// pollInterval is the current time between polls, which backs off while the bulb is idle.
+		pollInterval time.Duration
+		nextPoll     time.Time
+		// unreachable is whether the bulb did not respond to its last poll.
+		unreachable bool
+
+		// announced is whether the bulb's Homie attributes have been published.
+		announced bool
+		// homeAssistantConfigTopic is where the bulb's Home Assistant discovery was published, if anywhere.
+		homeAssistantConfigTopic string
+	}
+
+	// registry is the shared model of all configured bulbs.
+	registry struct {
+		mu             sync.Mutex
+		devicesByLabel map[string]*device
+
+		// onChange, if set, is called with a copy of a device whenever a command or an Update changes its state.
+		onChange func(device)
+
+		// autoConfigure, if set, adds a device for a discovered bulb that has none, and returns whether it did.
+		autoConfigure func(label string, info lifx.Info) bool
+	}
+)
+
+func newRegistry(c *config.Config) *registry {
+	r := &registry{
+		devicesByLabel: map[string]*device{},
+	}
+	for label, bulb := range c.BulbsByLabel {
+		r.devicesByLabel[label] = newDevice(bulb)
+	}
+	return r
+}
+
+func newDevice(bulb config.Bulb) *device {
+	return &device{
+		label:            bulb.Label,
+		config:           bulb,
+		members:          bulb.Members,
+		idlePollInterval: bulb.PollInterval,
+		pollInterval:     bulb.PollInterval,
+	}
+}
+
+// discover discovers bulbs and reads their state into the registry.
+func (r *registry) discover(ctx context.Context) {
+	log, ctx := logger.FromContext(ctx)
+
+	log.Info("discovering bulbs")
+	discoverCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	bulbs, err := lifx.Discover(discoverCtx)
+	cancel()
+	if err != nil {
+		log.WithError(err).Error("could not discover bulbs")
+		return
+	}
+	log.Info("discovered bulbs")
+
+	seenLabels := map[string]bool{}
+	var seenLabelsMu sync.Mutex
+	var wg sync.WaitGroup
+
+	ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	for _, bulb := range bulbs {
+		bulb := bulb
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			state, err := bulb.State(ctx)
+			if err != nil {
+				log.WithError(err).Error("could not read bulb state")
+				return
+			}
+			log := logger.Background()
+			log.AddField("bulb", state.Label)
+			log.Info("found bulb")
+
+			d, ok := r.device(state.Label)
+			if !ok && r.autoConfigure == nil {
+				log.Warning("discovered bulb with no config")
+				return
+			}
+
+			// Info is static, so only read it for newly discovered bulbs.
+			info := d.info
+			if d.bulb == nil || info.MAC == nil {
+				info, err = bulb.Info(ctx)
+				if err != nil {
+					log.WithError(err).Error("could not read bulb info")
+					return
+				}
+			}
+
+			if !ok && !r.autoConfigure(state.Label, info) {
+				return
+			}
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.devicesByLabel[state.Label].bulb = bulb
+			r.devicesByLabel[state.Label].info = info
+			r.devicesByLabel[state.Label].state = state
+			r.devicesByLabel[state.Label].missed = 0
+			r.devicesByLabel[state.Label].unreachable = false
+
+			seenLabelsMu.Lock()
+			defer seenLabelsMu.Unlock()
+			seenLabels[state.Label] = true
+		}()
+	}
+	wg.Wait()
+
+	// Groups are made of whichever of their members were found.
+	for _, d := range r.devices() {
+		if len(d.members) == 0 {
+			continue
+		}
+		log := logger.Background()
+		log.AddField("bulb", d.label)
+
+		var members []lifx.Bulb
+		for _, label := range d.members {
+			if bulb, ok := r.bulb(label); ok && seenLabels[label] {
+				members = append(members, bulb)
+			}
+		}
+		if len(members) == 0 {
+			log.Warning("found no bulbs in group")
+			continue
+		}
+
+		group := lifx.NewGroup(d.label, members...)
+		state, err := group.State(ctx)
+		if err != nil {
+			log.WithError(err).Error("could not read group state")
+			continue
+		}
+		info, err := group.Info(ctx)
+		if err != nil {
+			log.WithError(err).Warning("could not read group info")
+		}
+
+		r.update(d.label, func(d *device) {
+			d.bulb = group
+			d.info = info
+			d.state = state
+			d.missed = 0
+			d.unreachable = false
+		})
+		seenLabels[d.label] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for label, d := range r.devicesByLabel {
+		if !seenLabels[label] {
+			d.missed++
+		}
+	}
+}
+
+// add adds an auto-configured device, unless there already is one for its label.
+// It returns false and the label of another device if that device shares any of its topics, checking and adding in one step so that two bulbs discovered at once cannot both claim a topic.
+func (r *registry) add(bulb config.Bulb) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.devicesByLabel[bulb.Label]; ok {
+		return "", true
+	}
+	for label, d := range r.devicesByLabel {
+		if sharesTopic(d.config.Topics, bulb.Topics) {
+			return label, false
+		}
+	}
+	d := newDevice(bulb)
+	d.auto = true
+	r.devicesByLabel[bulb.Label] = d
+	return "", true
+}
+
+// configure adds a configured device, or replaces the config of an existing device, e.g. when the config file is reloaded.
+func (r *registry) configure(bulb config.Bulb) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, ok := r.devicesByLabel[bulb.Label]
+	if !ok {
+		r.devicesByLabel[bulb.Label] = newDevice(bulb)
+		return
+	}
+	d.config = bulb
+	d.auto = false
+	d.members = bulb.Members
+	d.idlePollInterval = bulb.PollInterval
+}
+
+// remove removes a device, e.g. an auto-configured bulb that has gone, or a bulb removed from the config.
+func (r *registry) remove(label string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.devicesByLabel, label)
+}
+
+// device returns a copy of the device for a label.
+func (r *registry) device(label string) (device, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, ok := r.devicesByLabel[label]
+	if !ok {
+		return device{}, false
+	}
+	return *d, true
+}
+
+// devices returns a copy of all devices.
+func (r *registry) devices() []device {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var devices []device
+	for _, d := range r.devicesByLabel {
+		devices = append(devices, *d)
+	}
+	return devices
+}
+
+// bulb returns the bulb for a label, if it has been discovered.
+func (r *registry) bulb(label string) (lifx.Bulb, bool) {
+	d, ok := r.device(label)
+	return d.bulb, ok && d.bulb != nil
+}
+
+// related returns the label of a device, the groups it is in, and its members if it is a group.
+func (r *registry) related(label string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	labels := []string{label}
+	d, ok := r.devicesByLabel[label]
+	if !ok {
+		return labels
+	}
+	for _, other := range r.devicesByLabel {
+		if contains(other.members, label) || contains(d.members, other.label) {
+			labels = append(labels, other.label)
+		}
+	}
+	return labels
+}
+
+// labelForMAC returns the label of the bulb with a given MAC address.
+func (r *registry) labelForMAC(mac net.HardwareAddr) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for label, d := range r.devicesByLabel {
+		if bytes.Equal(d.info.MAC, mac) {
+			return label, true
+		}
+	}
+	return "", false
+}
+
+// labelForHomeAssistantID returns the label of the bulb with a given Home Assistant ID.
+func (r *registry) labelForHomeAssistantID(id string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for label, d := range r.devicesByLabel {
+		if d.info.MAC != nil && homeassistant.ID(d.info) == id {
+			return label, true
+		}
+	}
+	return "", false
+}
+
+// update changes the device for a label.
+func (r *registry) update(label string, f func(*device)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d, ok := r.devicesByLabel[label]; ok {
+		f(d)
+	}
+}
+
+// setState caches the state of a bulb after a command, and polls it again soon to follow the transition.
+func (r *registry) setState(label string, state lifx.State) {
+	r.change(label, func(d *device) {
+		d.state = state
+		d.pollSoon()
+	})
+}
+
+// setPower caches the power of a bulb after a command, and polls it again soon to follow the transition.
+func (r *registry) setPower(label string, power lifx.Power) {
+	r.change(label, func(d *device) {
+		d.state.Power = power
+		d.pollSoon()
+	})
+}
+
+// applyUpdate caches the state broadcast by a bulb.
+func (r *registry) applyUpdate(u lifx.Update) {
+	label, ok := r.labelForMAC(u.MAC)
+	if !ok && u.State != nil {
+		label, ok = u.State.Label, true
+	}
+	if !ok {
+		return
+	}
+
+	r.change(label, func(d *device) {
+		if u.State != nil {
+			d.state = *u.State
+		}
+		d.state.Power = u.Power
+		d.pollSoon()
+	})
+}
+
+// change changes the device for a label, and calls onChange if its state or reachability changed.
+// Groups the device is in, and members of the device if it is a group, are polled soon to follow the change.
+func (r *registry) change(label string, f func(*device)) {
+	r.mu.Lock()
+	d, ok := r.devicesByLabel[label]
+	if !ok || d.bulb == nil {
+		r.mu.Unlock()
+		return
+	}
+	before := *d
+	f(d)
+	after := *d
+	if after.state != before.state {
+		for _, other := range r.devicesByLabel {
+			if other.bulb != nil && (contains(other.members, label) || contains(d.members, other.label)) {
+				other.pollSoon()
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	if after.state != before.state {
+		checkAdaptive(label, after.state)
+	}
+	if r.onChange != nil && (after.state != before.state || after.unreachable != before.unreachable) {
+		r.onChange(after)
+	}
+}
+
+func contains(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"go.eth.moe/catbus-lifx/config"
+)
+
+func TestRegistryAddSharedTopic(t *testing.T) {
+	r := newRegistry(&config.Config{})
+
+	// Bulbs discovered at once whose templated topics collide, e.g. with a template that only uses {group}.
+	var wg sync.WaitGroup
+	added := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		bulb := config.Bulb{
+			Label:  fmt.Sprintf("Lamp %d", i),
+			Topics: config.Topics{Power: "bedroom/power"},
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := r.add(bulb); ok {
+				added <- bulb.Label
+			}
+		}()
+	}
+	wg.Wait()
+	close(added)
+
+	var labels []string
+	for label := range added {
+		labels = append(labels, label)
+	}
+	if len(labels) != 1 {
+		t.Errorf("added %q, want only one bulb with the topic", labels)
+	}
+}
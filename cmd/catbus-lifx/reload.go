@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"go.eth.moe/catbus"
+	"go.eth.moe/catbus-lifx/config"
+	"go.eth.moe/logger"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 5 * time.Second
+
+// watchConfig sends the config whenever its file changes, or the bridge receives SIGHUP.
+// A config that is not valid is logged and skipped, so the bridge keeps its current config.
+func watchConfig(path string) <-chan *config.Config {
+	reloads := make(chan *config.Config)
+
+	go func() {
+		log := logger.Background()
+		log.AddField("config-path", path)
+
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+
+		modTime := fileModTime(path)
+		for {
+			select {
+			case <-hangups:
+			case <-ticker.C:
+				t := fileModTime(path)
+				if t.Equal(modTime) {
+					continue
+				}
+				modTime = t
+			}
+
+			c, err := config.ParseFile(path)
+			if err != nil {
+				log.WithError(err).Error("could not reload config, so keeping the current config")
+				continue
+			}
+			log.Info("reloaded config")
+			reloads <- c
+		}
+	}()
+	return reloads
+}
+
+// comparableMQTT returns MQTT options that reflect.DeepEqual can compare, as a tls.Config holds functions and locks.
+func comparableMQTT(m config.MQTT) interface{} {
+	type comparable struct {
+		config.MQTT
+
+		tls                bool
+		certificates       []tls.Certificate
+		caSubjects         [][]byte
+		serverName         string
+		insecureSkipVerify bool
+	}
+	c := comparable{MQTT: m, tls: m.TLS != nil}
+	c.TLS = nil
+	if m.TLS != nil {
+		c.certificates = m.TLS.Certificates
+		if m.TLS.RootCAs != nil {
+			c.caSubjects = m.TLS.RootCAs.Subjects()
+		}
+		c.serverName = m.TLS.ServerName
+		c.insecureSkipVerify = m.TLS.InsecureSkipVerify
+	}
+	return c
+}
+
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reloadConfig applies the bulbs, groups, and scenes of a new config in place, and warns about other changes, which need a restart.
+func reloadConfig(broker catbus.Client, prev, next *config.Config, mode runMode) {
+	log := logger.Background()
+
+	restartOnly := []struct {
+		setting    string
+		prev, next interface{}
+	}{
+		{"mqttBroker", prev.BrokerURI, next.BrokerURI},
+		{"mqtt", comparableMQTT(prev.MQTT), comparableMQTT(next.MQTT)},
+		{"availabilityTopic", prev.AvailabilityTopic, next.AvailabilityTopic},
+		{"layout", prev.Layout, next.Layout},
+		{"homie", prev.Homie, next.Homie},
+		{"homeAssistant", prev.HomeAssistant, next.HomeAssistant},
+		{"location", prev.Location, next.Location},
+		{"adaptive", prev.Adaptive, next.Adaptive},
+		{"alarms", prev.AlarmsByName, next.AlarmsByName},
+		{"schedules", prev.Schedules, next.Schedules},
+		{"topicTemplate", prev.TopicTemplate, next.TopicTemplate},
+		{"autoConfigure", prev.AutoConfigure, next.AutoConfigure},
+	}
+	for _, s := range restartOnly {
+		if !reflect.DeepEqual(s.prev, s.next) {
+			log := logger.Background()
+			log.AddField("setting", s.setting)
+			log.Warning("config setting changed, but only takes effect after a restart")
+		}
+	}
+
+	// Bulbs that are already configured the same are left alone, and auto-configured bulbs that are now in the config are reconfigured.
+	for label, bulb := range next.BulbsByLabel {
+		d, ok := devices.device(label)
+		if ok && !d.auto && reflect.DeepEqual(d.config, bulb) {
+			continue
+		}
+
+		log := logger.Background()
+		log.AddField("bulb", label)
+
+		if ok && mode.actuates() {
+			unsubscribeBulb(broker, prev, d.config)
+			delete(subscribedAutoLabels, label)
+		}
+		devices.configure(bulb)
+		removeBulb(label)
+		addBulb(next, bulb)
+		setAdaptive(label, bulb.Adaptive)
+		if mode.actuates() {
+			subscribeBulb(broker, next, bulb)
+		}
+
+		if ok {
+			log.Info("reconfigured bulb")
+		} else {
+			log.Info("added bulb")
+		}
+	}
+
+	for _, d := range devices.devices() {
+		if _, ok := next.BulbsByLabel[d.label]; ok || d.auto {
+			continue
+		}
+
+		if mode.actuates() {
+			unsubscribeBulb(broker, prev, d.config)
+		}
+		if mode.observes() {
+			removeMissingBulb(broker, d)
+		}
+		removeBulb(d.label)
+		setAdaptive(d.label, false)
+		devices.remove(d.label)
+
+		log := logger.Background()
+		log.AddField("bulb", d.label)
+		log.Info("removed bulb")
+	}
+
+	setScenes(next)
+	if mode.actuates() {
+		subscribed, wanted := len(prev.ScenesByName) > 0, len(next.ScenesByName) > 0
+		moved := prev.SceneTopic != next.SceneTopic
+		if subscribed && (!wanted || moved) {
+			if err := broker.Unsubscribe(prev.SceneTopic); err != nil {
+				log.WithError(err).Error("could not unsubscribe from scenes")
+			}
+		}
+		if wanted && (!subscribed || moved) {
+			subscribeScenes(broker)
+		}
+	}
+
+	log.Info("applied reloaded config")
+}
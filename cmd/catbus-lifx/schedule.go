@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.eth.moe/catbus"
+	"go.eth.moe/catbus-lifx/config"
+	"go.eth.moe/catbus-lifx/cron"
+	"go.eth.moe/catbus-lifx/lifx"
+	"go.eth.moe/logger"
+)
+
+// runSchedules applies each schedule at its times, until the context is cancelled.
+func runSchedules(ctx context.Context, broker catbus.Client, c *config.Config) {
+	var jobs []cron.Job
+	for i, schedule := range c.Schedules {
+		i, schedule := i, schedule
+		jobs = append(jobs, cron.Job{
+			Spec:    schedule.When,
+			CatchUp: schedule.CatchUp,
+			Run: func(due time.Time) {
+				log := logger.Background()
+				log.AddField("schedule", i)
+				log.AddField("due", due)
+
+				if schedule.Scene != "" {
+					log.AddField("scene", schedule.Scene)
+					applyScene(broker, c.ScenesByName[schedule.Scene])
+				} else {
+					log.AddField("bulb", schedule.Label)
+					pipelineFor(schedule.Label).send(broker, commandForSchedule(schedule))
+				}
+				log.Info("applied schedule")
+			},
+		})
+	}
+
+	s := &cron.Scheduler{Clock: cron.SystemClock, Jobs: jobs}
+	s.Run(ctx)
+}
+
+func commandForSchedule(s config.Schedule) command {
+	c := command{
+		adjustmentsByField: map[field][]adjustment{},
+		colorTransition:    transition(s.Label, 100*time.Millisecond),
+		powerTransition:    transition(s.Label, 500*time.Millisecond),
+	}
+	if s.Transition != 0 {
+		c.colorTransition = s.Transition
+		c.powerTransition = s.Transition
+	}
+
+	if s.Power != nil {
+		c.adjustmentsByField[fieldPower] = []adjustment{setTo(0)}
+		if *s.Power == lifx.On {
+			c.adjustmentsByField[fieldPower] = []adjustment{setTo(1)}
+		}
+	}
+	valuesByField := map[field]*int{
+		fieldHue:        s.Hue,
+		fieldSaturation: s.Saturation,
+		fieldBrightness: s.Brightness,
+		fieldKelvin:     s.Kelvin,
+	}
+	for f, v := range valuesByField {
+		if v != nil {
+			c.adjustmentsByField[f] = []adjustment{setTo(*v)}
+		}
+	}
+	return c
+}
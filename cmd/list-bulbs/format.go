@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+// columns are the header of --format=csv and --format=table, in the order of bulb.row.
+var columns = []string{"label", "group", "mac", "ip", "port", "product", "firmware", "signal", "power", "hue", "saturation", "brightness", "kelvin"}
+
+// row returns a bulb's fields as text, with an empty port or signal if it is unknown.
+func (b bulb) row() []string {
+	signal := ""
+	if b.Signal != nil {
+		signal = strconv.Itoa(*b.Signal)
+	}
+	port := ""
+	if b.Port != 0 {
+		port = strconv.Itoa(b.Port)
+	}
+	return []string{
+		b.Label,
+		b.Group,
+		b.MAC,
+		b.IP,
+		port,
+		b.Product,
+		b.Firmware,
+		signal,
+		b.Power,
+		strconv.Itoa(b.Hue),
+		strconv.Itoa(b.Saturation),
+		strconv.Itoa(b.Brightness),
+		strconv.Itoa(b.Kelvin),
+	}
+}
+
+// writeText writes a block per bulb, for people.
+func writeText(w io.Writer, bulbs []bulb) error {
+	var blocks []string
+	for _, b := range bulbs {
+		signal := "unknown"
+		if b.Signal != nil {
+			signal = fmt.Sprintf("%v dBm", *b.Signal)
+		}
+		address := "unknown"
+		if b.IP != "" {
+			address = fmt.Sprintf("%v:%v", b.IP, b.Port)
+		}
+		blocks = append(blocks, fmt.Sprintf(`%s:
+	power:      %v
+	hue:        %v°
+	saturation: %v%%
+	brightness: %v%%
+	kelvin:     %vK
+	group:      %v
+	mac:        %v
+	address:    %v
+	product:    %v
+	firmware:   %v
+	signal:     %v`, b.Label, b.Power, b.Hue, b.Saturation, b.Brightness, b.Kelvin, b.Group, b.MAC, address, b.Product, b.Firmware, signal))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(blocks, "\n\n"))
+	return err
+}
+
+// writeJSON writes an array of bulbs.
+func writeJSON(w io.Writer, bulbs []bulb) error {
+	// An empty array is easier for scripts than null.
+	if bulbs == nil {
+		bulbs = []bulb{}
+	}
+	bytes, err := json.MarshalIndent(bulbs, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(bytes))
+	return err
+}
+
+// writeCSV writes a header of columns, then a row per bulb.
+func writeCSV(w io.Writer, bulbs []bulb) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	for _, b := range bulbs {
+		if err := cw.Write(b.row()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeTable writes aligned columns, for people.
+func writeTable(w io.Writer, bulbs []bulb) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, b := range bulbs {
+		fmt.Fprintln(tw, strings.Join(b.row(), "\t"))
+	}
+	return tw.Flush()
+}
+
+// writeTemplate executes the template for each bulb, on its own line.
+func writeTemplate(w io.Writer, tmpl *template.Template, bulbs []bulb) error {
+	for _, b := range bulbs {
+		if err := tmpl.Execute(w, b); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -8,10 +8,13 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
 	"log"
+	"os"
+	"path"
 	"sort"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"go.eth.moe/catbus-lifx/lifx"
@@ -19,36 +22,211 @@ import (
 
 var (
 	timeout = flag.Duration("timeout", 10*time.Second, "how long to wait for bulbs to respond")
+
+	format       = flag.String("format", "text", "text, json, csv, table, or template")
+	templateText = flag.String("template", "", "Go text/template for each bulb, for --format=template, e.g. '{{.Label}} {{.IP}}'")
+
+	labelGlob = flag.String("label", "", "only list bulbs whose label matches this glob, e.g. 'Kitchen*'")
+	group     = flag.String("group", "", "only list bulbs in this group in the Lifx app")
+
+	watchBulbs = flag.Bool("watch", false, "keep polling bulbs, showing a table that refreshes in a terminal, or printing a JSON event per line for each change when piped")
+	interval   = flag.Duration("interval", 2*time.Second, "how often to poll bulbs, for --watch")
 )
 
+// found is a discovered bulb, and what is listed about it.
+type found struct {
+	device lifx.Bulb
+	bulb   bulb
+}
+
+// bulb is everything listed about a bulb, with the field names used by --template.
+type bulb struct {
+	Label    string `json:"label"`
+	Group    string `json:"group"`
+	MAC      string `json:"mac"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Product  string `json:"product"`
+	Firmware string `json:"firmware"`
+	// Signal is in dBm, and is nil if the bulb did not report it.
+	Signal *int `json:"signal"`
+
+	Power      string `json:"power"`
+	Hue        int    `json:"hue"`
+	Saturation int    `json:"saturation"`
+	Brightness int    `json:"brightness"`
+	Kelvin     int    `json:"kelvin"`
+}
+
 func main() {
 	flag.Parse()
 
-	timeout := *timeout
+	if *labelGlob != "" {
+		if _, err := path.Match(*labelGlob, ""); err != nil {
+			log.Fatalf("invalid --label glob %q: %v", *labelGlob, err)
+		}
+	}
 
-	ctx, _ := context.WithTimeout(context.Background(), timeout)
-	bulbs, err := lifx.Discover(ctx)
-	if err != nil {
-		log.Fatalf("could not discover bulbs: %v", err)
+	if *watchBulbs && *interval <= 0 {
+		log.Fatalf("interval must be positive, found %v", *interval)
 	}
 
-	var stats []string
-	for _, bulb := range bulbs {
-		ctx, _ := context.WithTimeout(context.Background(), timeout)
-		state, err := bulb.State(ctx)
+	var tmpl *template.Template
+	switch *format {
+	case "text", "json", "csv", "table":
+	case "template":
+		if *templateText == "" {
+			log.Fatal("must set --template with --format=template")
+		}
+		var err error
+		tmpl, err = template.New("bulb").Parse(*templateText)
 		if err != nil {
-			log.Printf("a bulb was discovered but we could not query it: %v", err)
-			continue
+			log.Fatalf("invalid --template: %v", err)
 		}
+	default:
+		log.Fatalf("format must be text, json, csv, table, or template, found %q", *format)
+	}
 
-		stats = append(stats, fmt.Sprintf(`%s:
-	power:      %v
-	hue:        %v°
-	saturation: %v%%
-	brightness: %v%%
-	kelvin:     %vK`, state.Label, state.Power, state.Color.Hue, state.Color.Saturation, state.Color.Brightness, state.Color.Kelvin))
+	if *watchBulbs {
+		table := isTerminal(os.Stdout)
+		switch *format {
+		case "text":
+		case "table":
+			table = true
+		case "json":
+			table = false
+		default:
+			log.Fatalf("format must be table or json with --watch, found %q", *format)
+		}
+		watch(os.Stdout, table)
+		return
 	}
 
-	sort.Strings(stats)
-	fmt.Println(strings.Join(stats, "\n\n"))
+	discovered, err := discover()
+	if err != nil {
+		log.Fatalf("could not discover bulbs: %v", err)
+	}
+	var bulbs []bulb
+	for _, f := range discovered {
+		bulbs = append(bulbs, f.bulb)
+	}
+
+	switch *format {
+	case "text":
+		err = writeText(os.Stdout, bulbs)
+	case "json":
+		err = writeJSON(os.Stdout, bulbs)
+	case "csv":
+		err = writeCSV(os.Stdout, bulbs)
+	case "table":
+		err = writeTable(os.Stdout, bulbs)
+	case "template":
+		err = writeTemplate(os.Stdout, tmpl, bulbs)
+	}
+	if err != nil {
+		log.Fatalf("could not write bulbs: %v", err)
+	}
+}
+
+// discover returns the bulbs that match --label and --group, sorted by label.
+func discover() ([]found, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	devices, err := lifx.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var bulbs []found
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, b := range devices {
+		b := b
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+			listed, err := query(ctx, b)
+			if err != nil {
+				log.Printf("a bulb was discovered but we could not query it: %v", err)
+				return
+			}
+			if !matches(listed) {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			bulbs = append(bulbs, found{device: b, bulb: listed})
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(bulbs, func(i, j int) bool { return bulbs[i].bulb.Label < bulbs[j].bulb.Label })
+	return bulbs, nil
+}
+
+// query reads a bulb's state, and its info and signal if it reports them.
+// A bulb whose info could not be read is still listed, with empty fields for it.
+func query(ctx context.Context, b lifx.Bulb) (bulb, error) {
+	state, err := b.State(ctx)
+	if err != nil {
+		return bulb{}, err
+	}
+	info, err := b.Info(ctx)
+	if err != nil {
+		log.Printf("could not read info of bulb %q: %v", state.Label, err)
+	}
+
+	listed := newBulb(state, info)
+	if signal, err := b.Signal(ctx); err != nil {
+		log.Printf("could not read signal of bulb %q: %v", state.Label, err)
+	} else {
+		listed.Signal = &signal
+	}
+	return listed, nil
+}
+
+func newBulb(state lifx.State, info lifx.Info) bulb {
+	b := bulb{
+		Label:    state.Label,
+		Group:    info.Group,
+		MAC:      info.MAC.String(),
+		Product:  info.Product.Name,
+		Firmware: info.Firmware,
+
+		Power:      state.Power.String(),
+		Hue:        state.Color.Hue,
+		Saturation: state.Color.Saturation,
+		Brightness: state.Color.Brightness,
+		Kelvin:     state.Color.Kelvin,
+	}
+	if info.Addr != nil {
+		b.IP = info.Addr.IP.String()
+		b.Port = info.Addr.Port
+	}
+	return b
+}
+
+// key identifies a bulb across polls by its MAC address, or by its label if its info could not be read.
+func (b bulb) key() string {
+	if b.MAC == "" {
+		return "label:" + b.Label
+	}
+	return b.MAC
+}
+
+// matches returns whether a bulb matches --label and --group, whose case is ignored.
+func matches(b bulb) bool {
+	if *labelGlob != "" {
+		if ok, _ := path.Match(*labelGlob, b.Label); !ok {
+			return false
+		}
+	}
+	if *group != "" && !strings.EqualFold(*group, b.Group) {
+		return false
+	}
+	return true
 }
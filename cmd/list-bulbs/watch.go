@@ -0,0 +1,232 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rediscoverInterval is how often --watch discovers bulbs again, to find new bulbs and refresh their info and signal.
+	rediscoverInterval = 30 * time.Second
+
+	// recentEvents is how many events the --watch table shows beneath it.
+	recentEvents = 10
+)
+
+// event is a change seen by --watch, printed as a line of JSON when piped.
+type event struct {
+	Time time.Time `json:"time"`
+	// Event is "found", "changed", or "lost".
+	Event string `json:"event"`
+	// Changed is the fields of a changed bulb that changed.
+	Changed []string `json:"changed,omitempty"`
+	Bulb    bulb     `json:"bulb"`
+}
+
+// watch polls bulbs until the process is stopped, writing either a table that refreshes, or an event per change.
+// Bulbs are discovered again in the background, as discovery takes the whole --timeout.
+func watch(w io.Writer, table bool) {
+	// bulbsByKey are the bulbs being watched, as last seen.
+	bulbsByKey := map[string]found{}
+	var recent []event
+
+	// discoveries is nil if the discovery failed, which is logged.
+	discoveries := make(chan []found, 1)
+	rediscover := func() {
+		discovered, err := discover()
+		if err != nil {
+			log.Printf("could not discover bulbs: %v", err)
+		}
+		discoveries <- discovered
+	}
+	go rediscover()
+	lastDiscovery := time.Now()
+	discovering := true
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		var seen []found
+		select {
+		case discovered := <-discoveries:
+			discovering = false
+			if discovered == nil {
+				continue
+			}
+			seen = discovered
+		case <-ticker.C:
+			seen = poll(bulbsByKey)
+			if !discovering && time.Since(lastDiscovery) >= rediscoverInterval {
+				go rediscover()
+				lastDiscovery = time.Now()
+				discovering = true
+			}
+		}
+
+		events := diff(bulbsByKey, seen)
+		for _, e := range events {
+			if e.Event == "lost" {
+				delete(bulbsByKey, e.Bulb.key())
+			}
+		}
+		for _, f := range seen {
+			bulbsByKey[f.bulb.key()] = f
+		}
+
+		if !table {
+			enc := json.NewEncoder(w)
+			for _, e := range events {
+				if err := enc.Encode(e); err != nil {
+					log.Fatalf("could not write event: %v", err)
+				}
+			}
+			continue
+		}
+		recent = append(recent, events...)
+		if len(recent) > recentEvents {
+			recent = recent[len(recent)-recentEvents:]
+		}
+		if err := writeWatchTable(w, bulbsByKey, recent); err != nil {
+			log.Fatalf("could not write bulbs: %v", err)
+		}
+	}
+}
+
+// poll reads the state of every watched bulb, keeping their info and signal from the last discovery.
+// Bulbs relabeled out of --label are left out, as if they did not respond.
+func poll(bulbsByKey map[string]found) []found {
+	timeout := *timeout
+	if *interval < timeout {
+		timeout = *interval
+	}
+
+	var seen []found
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, f := range bulbsByKey {
+		f := f
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			state, err := f.device.State(ctx)
+			if err != nil {
+				return
+			}
+			f.bulb.Label = state.Label
+			f.bulb.Power = state.Power.String()
+			f.bulb.Hue = state.Color.Hue
+			f.bulb.Saturation = state.Color.Saturation
+			f.bulb.Brightness = state.Color.Brightness
+			f.bulb.Kelvin = state.Color.Kelvin
+			if !matches(f.bulb) {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			seen = append(seen, f)
+		}()
+	}
+	wg.Wait()
+	return seen
+}
+
+// diff returns the events between the bulbs last seen and the bulbs seen now, in label order.
+// Bulbs that were not seen now, e.g. because they did not respond, are lost, and are found again by the next discovery.
+func diff(bulbsByKey map[string]found, seen []found) []event {
+	now := time.Now()
+	var events []event
+
+	seenKeys := map[string]bool{}
+	for _, f := range seen {
+		seenKeys[f.bulb.key()] = true
+		prev, ok := bulbsByKey[f.bulb.key()]
+		if !ok {
+			events = append(events, event{Time: now, Event: "found", Bulb: f.bulb})
+			continue
+		}
+		if changed := changedFields(prev.bulb, f.bulb); len(changed) > 0 {
+			events = append(events, event{Time: now, Event: "changed", Changed: changed, Bulb: f.bulb})
+		}
+	}
+	for key, f := range bulbsByKey {
+		if !seenKeys[key] {
+			events = append(events, event{Time: now, Event: "lost", Bulb: f.bulb})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Bulb.Label < events[j].Bulb.Label })
+	return events
+}
+
+// changedFields returns the JSON names of the fields that differ between two bulbs.
+func changedFields(a, b bulb) []string {
+	var changed []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		name := strings.Split(va.Type().Field(i).Tag.Get("json"), ",")[0]
+		// Signal drifts by itself, so it is not worth reporting.
+		if name == "signal" {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// writeWatchTable clears the terminal, and writes the table of bulbs and the most recent events.
+func writeWatchTable(w io.Writer, bulbsByKey map[string]found, recent []event) error {
+	var bulbs []bulb
+	for _, f := range bulbsByKey {
+		bulbs = append(bulbs, f.bulb)
+	}
+	sort.Slice(bulbs, func(i, j int) bool { return bulbs[i].Label < bulbs[j].Label })
+
+	// Move to the top left, and clear the screen.
+	if _, err := fmt.Fprintf(w, "\x1b[H\x1b[2J"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Every %v, at %v\n\n", *interval, time.Now().Format("15:04:05")); err != nil {
+		return err
+	}
+	if err := writeTable(w, bulbs); err != nil {
+		return err
+	}
+	if len(recent) > 0 {
+		fmt.Fprintln(w)
+	}
+	for _, e := range recent {
+		line := fmt.Sprintf("%v  %v %q", e.Time.Format("15:04:05"), e.Event, e.Bulb.Label)
+		if len(e.Changed) > 0 {
+			line += ": " + strings.Join(e.Changed, ", ")
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTerminal returns whether a file is a terminal, rather than e.g. a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
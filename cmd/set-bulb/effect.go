@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.eth.moe/catbus-lifx/effects"
+	"go.eth.moe/catbus-lifx/lifx"
+)
+
+// runEffect runs an effect on bulbs, with their phases offset in the order given, until interrupted.
+// The bulbs are then returned to their colors from before the effect.
+func runEffect(raw string, labels []string) {
+	effect, period, err := effects.Parse(raw)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bulbsByLabel, _ := discoverByLabel()
+
+	var bulbs []lifx.Bulb
+	for _, label := range labels {
+		bulb, ok := bulbsByLabel[label]
+		if !ok {
+			log.Fatalf("could not find bulb %q", label)
+		}
+		bulbs = append(bulbs, bulb)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	stop, err := effects.Start(ctx, effect, period, bulbs...)
+	cancel()
+	if err != nil {
+		log.Fatalf("could not start effect: %v", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	stop(true)
+}
@@ -2,20 +2,24 @@
 //
 // SPDX-License-Identifier: MIT
 
-// Binary set-bulb sets color properties for named Lifx bulbs.
+// Binary set-bulb sets color properties for Lifx bulbs picked by label, group, MAC, or IP, or applies and captures scenes.
 package main
 
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"sync"
 	"time"
 
+	"go.eth.moe/catbus-lifx/config"
 	"go.eth.moe/catbus-lifx/lifx"
 )
 
 var (
-	bulbLabel = flag.String("bulb", "", "bulb to change")
+	bulbSelectors selectorList
 
 	power      = flag.String("power", "", "on or off")
 	hue        = flag.Int("hue", -1, "0 – 359°")
@@ -23,47 +27,98 @@ var (
 	brightness = flag.Int("brightness", -1, "0 – 100%")
 	kelvin     = flag.Int("kelvin", -1, "2500K – 9000K")
 
+	configPath   = flag.String("config-path", "", "path to the config file, in JSON, YAML, or TOML, for --scene and --capture-scene")
+	scene        = flag.String("scene", "", "scene from the config to apply")
+	captureScene = flag.String("capture-scene", "", "print the current state of the config's bulbs as a scene with this name")
+
+	effect = flag.String("effect", "", "software effect to run until interrupted, optionally with a period, e.g. \"cycle 10s\"")
+
 	timeout  = flag.Duration("timeout", 10*time.Second, "how long to wait for bulbs to respond")
 	duration = flag.Duration("duration", 500*time.Millisecond, "how long to smooth transitions over")
 )
 
+func init() {
+	flag.Var(&bulbSelectors, "bulb", "bulbs to change, comma-separated or repeated, as label globs, group:NAME, mac:ADDRESS, ip:ADDRESS, or all; or for --effect, labels in order")
+}
+
 func main() {
 	flag.Parse()
 
-	if *bulbLabel == "" {
+	if *scene != "" || *captureScene != "" {
+		if *configPath == "" {
+			log.Fatal("must set --config-path with --scene or --capture-scene")
+		}
+		c, err := config.ParseFile(*configPath)
+		if err != nil {
+			log.Fatalf("could not load config: %v", err)
+		}
+
+		if *scene != "" {
+			applyScene(c, *scene)
+		} else {
+			printScene(c, *captureScene)
+		}
+		return
+	}
+
+	if len(bulbSelectors) == 0 {
 		log.Fatal("must set --bulb")
 	}
 
-	if *power != "" && !(*power == "on" || *power == "off") {
-		log.Fatalf("power must be on or off, found %v", *power)
+	if *effect != "" {
+		runEffect(*effect, bulbSelectors)
+		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
-	bulbs, err := lifx.Discover(ctx)
-	if err != nil {
-		log.Fatalf("could not discover bulbs: %v", err)
+	if *power != "" && !(*power == "on" || *power == "off") {
+		log.Fatalf("power must be on or off, found %v", *power)
 	}
 
-	var bulb lifx.Bulb
-	var state lifx.State
-	for _, b := range bulbs {
-		ctx, _ := context.WithTimeout(context.Background(), *timeout)
-		s, err := b.State(ctx)
+	var selectors []selector
+	for _, raw := range bulbSelectors {
+		s, err := parseSelector(raw)
 		if err != nil {
-			continue
+			log.Fatal(err)
 		}
-		if s.Label == *bulbLabel {
-			bulb = b
-			state = s
-			break
+		selectors = append(selectors, s)
+	}
+
+	targets, ok := selectBulbs(selectors)
+	if len(targets) == 0 {
+		log.Fatal("could not find any bulbs")
+	}
+
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		i, t := i, t
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = setBulb(t.bulb, t.state)
+		}()
+	}
+	wg.Wait()
+
+	for i, t := range targets {
+		if errs[i] != nil {
+			fmt.Printf("%v: %v\n", t.state.Label, errs[i])
+			ok = false
+		} else {
+			fmt.Printf("%v: ok\n", t.state.Label)
 		}
 	}
-	cancel()
-	if bulb == nil {
-		log.Fatalf("could not find bulb %q", *bulbLabel)
+	if !ok {
+		os.Exit(1)
 	}
+}
 
-	colorChange := *hue != -1 || *saturation != 0 || *brightness != 0 || *kelvin != 0
+// setBulb applies the color and power flags to a bulb, setting the color first if the bulb is turning on so it does not flash the old color.
+func setBulb(bulb lifx.Bulb, state lifx.State) error {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	colorChange := *hue != -1 || *saturation != -1 || *brightness != -1 || *kelvin != -1
 
 	color := state.Color
 	if *hue != -1 {
@@ -79,35 +134,30 @@ func main() {
 		color.Kelvin = *kelvin
 	}
 
-	// if color && power=on, do color first.
 	if colorChange && *power == "on" {
-		ctx, _ = context.WithTimeout(context.Background(), *timeout)
 		if err := bulb.SetColor(ctx, color, 0); err != nil {
-			log.Fatalf("could not set color: %v", err)
+			return fmt.Errorf("could not set color: %w", err)
 		}
-		ctx, _ = context.WithTimeout(context.Background(), *timeout)
 		if err := bulb.SetPower(ctx, lifx.On, *duration); err != nil {
-			log.Fatalf("could not set power: %v", err)
+			return fmt.Errorf("could not set power: %w", err)
 		}
-	} else {
-		if *power == "on" {
-			ctx, _ = context.WithTimeout(context.Background(), *timeout)
-			if err := bulb.SetPower(ctx, lifx.On, *duration); err != nil {
-				log.Fatalf("could not set power: %v", err)
-			}
+		return nil
+	}
+
+	switch *power {
+	case "on":
+		if err := bulb.SetPower(ctx, lifx.On, *duration); err != nil {
+			return fmt.Errorf("could not set power: %w", err)
 		}
-		if *power == "off" {
-			ctx, _ = context.WithTimeout(context.Background(), *timeout)
-			if err := bulb.SetPower(ctx, lifx.Off, *duration); err != nil {
-				log.Fatalf("could not set power: %v", err)
-			}
+	case "off":
+		if err := bulb.SetPower(ctx, lifx.Off, *duration); err != nil {
+			return fmt.Errorf("could not set power: %w", err)
 		}
-
-		if colorChange {
-			ctx, _ = context.WithTimeout(context.Background(), *timeout)
-			if err := bulb.SetColor(ctx, color, *duration); err != nil {
-				log.Fatalf("could not set color: %v", err)
-			}
+	}
+	if colorChange {
+		if err := bulb.SetColor(ctx, color, *duration); err != nil {
+			return fmt.Errorf("could not set color: %w", err)
 		}
 	}
+	return nil
 }
@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.eth.moe/catbus-lifx/config"
+	"go.eth.moe/catbus-lifx/lifx"
+)
+
+// applyScene sets every bulb in a scene, and every member of its groups, at the same time, as the bridge does.
+// Bulbs set to the same color, or the same power, are sent it together, as with a group.
+func applyScene(c *config.Config, name string) {
+	scene, ok := c.ScenesByName[name]
+	if !ok {
+		log.Fatalf("could not find scene %q", name)
+	}
+
+	bulbsByLabel, statesByLabel := discoverByLabel()
+
+	failed := false
+	targetsByLabel := map[string]lifx.State{}
+	for label, state := range scene.StatesByLabel {
+		labels := []string{label}
+		if members := c.BulbsByLabel[label].Members; len(members) > 0 {
+			labels = members
+		}
+		for _, l := range labels {
+			if _, ok := bulbsByLabel[l]; !ok {
+				log.Printf("could not find bulb %q", l)
+				failed = true
+				continue
+			}
+			targetsByLabel[l] = state
+		}
+	}
+
+	// Bulbs that are turning on change color first, so they do not flash the old color.
+	type colorChange struct {
+		color      lifx.HSBK
+		transition time.Duration
+	}
+	labelsByColor := map[colorChange][]string{}
+	labelsByPower := map[lifx.Power][]string{}
+	for label, state := range targetsByLabel {
+		change := colorChange{state.Color, scene.Transition}
+		if state.Power == lifx.On && statesByLabel[label].Power == lifx.Off {
+			change.transition = 0
+		}
+		labelsByColor[change] = append(labelsByColor[change], label)
+		labelsByPower[state.Power] = append(labelsByPower[state.Power], label)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var failedMu sync.Mutex
+	together := func(labels []string, f func(lifx.Bulb) error) {
+		var bulbs []lifx.Bulb
+		for _, label := range labels {
+			bulbs = append(bulbs, bulbsByLabel[label])
+		}
+		if err := f(lifx.NewGroup(name, bulbs...)); err != nil {
+			log.Printf("could not set bulbs %q: %v", labels, err)
+			failedMu.Lock()
+			failed = true
+			failedMu.Unlock()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for change, labels := range labelsByColor {
+		change, labels := change, labels
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			together(labels, func(b lifx.Bulb) error { return b.SetColor(ctx, change.color, change.transition) })
+		}()
+	}
+	wg.Wait()
+	for power, labels := range labelsByPower {
+		power, labels := power, labels
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			together(labels, func(b lifx.Bulb) error { return b.SetPower(ctx, power, scene.Transition) })
+		}()
+	}
+	wg.Wait()
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// printScene prints the current state of the config's bulbs as a scene, to be added to the config's "scenes".
+func printScene(c *config.Config, name string) {
+	_, statesByLabel := discoverByLabel()
+
+	scene := config.Scene{
+		Name:          name,
+		Transition:    *duration,
+		StatesByLabel: map[string]lifx.State{},
+	}
+	for label, bulb := range c.BulbsByLabel {
+		// Groups are set through their members, which are already in the scene.
+		if len(bulb.Members) > 0 {
+			continue
+		}
+		state, ok := statesByLabel[label]
+		if !ok {
+			log.Printf("could not find bulb %q", label)
+			continue
+		}
+		scene.StatesByLabel[label] = state
+	}
+
+	bytes, err := json.MarshalIndent(map[string]config.Scene{name: scene}, "", "\t")
+	if err != nil {
+		log.Fatalf("could not marshal scene: %v", err)
+	}
+	fmt.Println(string(bytes))
+}
+
+// discoverByLabel discovers all bulbs and reads their states, by label.
+func discoverByLabel() (map[string]lifx.Bulb, map[string]lifx.State) {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	bulbs, err := lifx.Discover(ctx)
+	if err != nil {
+		log.Fatalf("could not discover bulbs: %v", err)
+	}
+
+	bulbsByLabel := map[string]lifx.Bulb{}
+	statesByLabel := map[string]lifx.State{}
+	for _, bulb := range bulbs {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		state, err := bulb.State(ctx)
+		cancel()
+		if err != nil {
+			continue
+		}
+		bulbsByLabel[state.Label] = bulb
+		statesByLabel[state.Label] = state
+	}
+	return bulbsByLabel, statesByLabel
+}
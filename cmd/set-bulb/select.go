@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.eth.moe/catbus-lifx/lifx"
+)
+
+type (
+	// selectorList is the value of a flag that can be repeated, or given comma-separated values, or both.
+	selectorList []string
+
+	// selector picks bulbs: "all", "group:Kitchen", "mac:d073d5010203", "ip:10.0.0.5", or otherwise a label glob.
+	selector struct {
+		raw   string
+		kind  string
+		value string
+	}
+
+	// target is a bulb picked by a selector, with its state before the change.
+	target struct {
+		bulb  lifx.Bulb
+		state lifx.State
+	}
+)
+
+const (
+	selectAll   = "all"
+	selectGroup = "group"
+	selectMAC   = "mac"
+	selectIP    = "ip"
+	selectLabel = "label"
+)
+
+func (l *selectorList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *selectorList) Set(raw string) error {
+	for _, s := range strings.Split(raw, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+func parseSelector(raw string) (selector, error) {
+	if raw == selectAll {
+		return selector{raw: raw, kind: selectAll}, nil
+	}
+
+	s := selector{raw: raw, kind: selectLabel, value: raw}
+	for _, kind := range []string{selectGroup, selectMAC, selectIP} {
+		if strings.HasPrefix(raw, kind+":") {
+			s.kind, s.value = kind, strings.TrimPrefix(raw, kind+":")
+			if s.value == "" {
+				return selector{}, fmt.Errorf("selector %q must have a value after %v:", raw, kind)
+			}
+		}
+	}
+
+	switch s.kind {
+	case selectMAC:
+		// Accept MAC addresses as the Lifx app shows them, and with colons or dashes.
+		s.value = strings.NewReplacer(":", "", "-", "").Replace(strings.ToLower(s.value))
+	case selectIP:
+		if net.ParseIP(s.value) == nil {
+			return selector{}, fmt.Errorf("selector %q must have an IP address, found %q", raw, s.value)
+		}
+	case selectLabel:
+		if _, err := path.Match(s.value, ""); err != nil {
+			return selector{}, fmt.Errorf("selector %q is not a valid glob: %w", raw, err)
+		}
+	}
+	return s, nil
+}
+
+// needsInfo returns whether matching the selector needs the bulb's Info, which takes several round trips to read.
+func (s selector) needsInfo() bool {
+	return s.kind == selectGroup || s.kind == selectMAC || s.kind == selectIP
+}
+
+func (s selector) matches(state lifx.State, info lifx.Info) bool {
+	switch s.kind {
+	case selectAll:
+		return true
+	case selectGroup:
+		return strings.EqualFold(s.value, info.Group)
+	case selectMAC:
+		return info.MAC != nil && s.value == strings.ReplaceAll(info.MAC.String(), ":", "")
+	case selectIP:
+		return info.Addr != nil && info.Addr.IP.Equal(net.ParseIP(s.value))
+	default:
+		ok, _ := path.Match(s.value, state.Label)
+		return ok
+	}
+}
+
+// selectBulbs discovers bulbs, and returns those that match any of the selectors, by label.
+// It also returns whether every selector matched a bulb.
+func selectBulbs(selectors []selector) ([]target, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	bulbs, err := lifx.Discover(ctx)
+	if err != nil {
+		log.Fatalf("could not discover bulbs: %v", err)
+	}
+
+	needsInfo := false
+	for _, s := range selectors {
+		needsInfo = needsInfo || s.needsInfo()
+	}
+
+	var targets []target
+	matched := map[string]bool{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, bulb := range bulbs {
+		bulb := bulb
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+			state, err := bulb.State(ctx)
+			if err != nil {
+				return
+			}
+			var info lifx.Info
+			if needsInfo {
+				if info, err = bulb.Info(ctx); err != nil {
+					log.Printf("could not read info of bulb %q: %v", state.Label, err)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			selected := false
+			for _, s := range selectors {
+				if s.matches(state, info) {
+					matched[s.raw] = true
+					selected = true
+				}
+			}
+			if selected {
+				targets = append(targets, target{bulb: bulb, state: state})
+			}
+		}()
+	}
+	wg.Wait()
+
+	allMatched := true
+	for _, s := range selectors {
+		if !matched[s.raw] {
+			log.Printf("could not find bulbs for %q", s.raw)
+			allMatched = false
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i].state.Label < targets[j].state.Label })
+	return targets, allMatched
+}
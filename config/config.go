@@ -6,73 +6,897 @@ package config
 
 import (
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.eth.moe/catbus-lifx/cron"
+	"go.eth.moe/catbus-lifx/homie"
+	"go.eth.moe/catbus-lifx/lifx"
+	"go.eth.moe/logger"
+)
+
+// Layout is how bulbs are laid out as MQTT topics.
+type Layout string
+
+const (
+	// LayoutCatbus uses the explicit topics configured for each bulb.
+	LayoutCatbus = Layout("catbus")
+	// LayoutHomie publishes each bulb as a device following the Homie convention.
+	LayoutHomie = Layout("homie")
 )
 
+// DefaultPollInterval is how often an idle bulb is polled for its state, unless configured otherwise.
+const DefaultPollInterval = 30 * time.Second
+
+// DefaultCoalesceWindow is how long commands to a bulb are collected for before they are applied together, unless configured otherwise.
+const DefaultCoalesceWindow = 50 * time.Millisecond
+
 type (
 	Bulb struct {
 		Label  string
-		Topics struct {
-			Power      string
-			Hue        string
-			Saturation string
-			Brightness string
-			Kelvin     string
-		}
+		Topics Topics
+
+		// PollInterval is how often the bulb is polled for its state while it is idle.
+		PollInterval time.Duration
+
+		// CoalesceWindow is how long commands are collected for before they are applied, so that only the latest value of each field is sent.
+		CoalesceWindow time.Duration
+
+		// Members is the labels of the bulbs in a virtual group, and is empty for real bulbs.
+		Members []string
+
+		// Adaptive is whether the bulb's kelvin and brightness follow the sun.
+		Adaptive bool
+	}
+
+	Topics struct {
+		Power      string
+		Hue        string
+		Saturation string
+		Brightness string
+		Kelvin     string
+
+		// Availability is optional, and if set is either "online" or "offline".
+		Availability string
+
+		// Effect is optional, and if set is the software effect running on the bulb, or "none".
+		Effect string
+	}
+
+	// Availability is where and how something's availability is published.
+	Availability struct {
+		Topic   string
+		Online  string
+		Offline string
+	}
+
+	// HomeAssistant configures Home Assistant MQTT discovery.
+	HomeAssistant struct {
+		// DiscoveryPrefix is where discovery documents are published, usually "homeassistant".
+		DiscoveryPrefix string
+		// TopicPrefix is where each bulb's JSON state & command topics live.
+		TopicPrefix string
+	}
+
+	// Scene is a named state for several bulbs, applied to all of them together.
+	Scene struct {
+		Name string
+		// Transition is how long to smooth the change over.
+		Transition time.Duration
+
+		StatesByLabel map[string]lifx.State
+	}
+
+	// Alarm is a wake-up routine, which ramps bulbs from off to full warm white, finishing at a given time.
+	Alarm struct {
+		Name   string
+		Labels []string
+
+		// Duration is how long the ramp takes.
+		Duration time.Duration
+
+		// Topic is optional, and if set receives the times to finish at, as "15:04" or RFC 3339, or "none" to cancel.
+		Topic string
+
+		// Schedule is nil if the alarm is only set through Topic.
+		Schedule *AlarmSchedule
+	}
+
+	// AlarmSchedule is when an alarm finishes each week.
+	AlarmSchedule struct {
+		Hour   int
+		Minute int
+		// Days are the days of the week the alarm is set for.
+		Days []time.Weekday
+	}
+
+	// Schedule is a change to a bulb, or a scene, applied at the times of a cron spec.
+	Schedule struct {
+		When cron.Spec
+		// CatchUp is how long after a missed time, e.g. while the bridge was stopped, the schedule is still applied when the bridge starts.
+		CatchUp time.Duration
+
+		// Scene is the scene to apply, or empty to change Label instead.
+		Scene string
+
+		Label string
+		// Power and each part of Color are nil if they are left unchanged.
+		Power      *lifx.Power
+		Hue        *int
+		Saturation *int
+		Brightness *int
+		Kelvin     *int
+		Transition time.Duration
+	}
+
+	// TopicTemplate generates the topics of bulbs that do not configure them explicitly.
+	TopicTemplate struct {
+		// Template is a topic with variables in braces, e.g. "home/{room}/{name}/{field}".
+		Template string
+		// Variables are the default values of variables, which each bulb can override.
+		Variables map[string]string
+
+		// Availability and Effect are whether to also generate these optional topics.
+		Availability bool
+		Effect       bool
+	}
+
+	// Location is where the bulbs are, for calculating sunrise and sunset.
+	Location struct {
+		// Latitude and Longitude are in degrees, positive north and east.
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	}
+
+	// Adaptive configures adaptive white, where bulbs' kelvin and brightness follow the sun.
+	// They are warmest and dimmest from sunset to sunrise, and coolest and brightest at solar noon.
+	Adaptive struct {
+		// Latitude and Longitude are in degrees, positive north and east.
+		Latitude  float64
+		Longitude float64
+
+		MinKelvin     int
+		MaxKelvin     int
+		MinBrightness int
+		MaxBrightness int
+
+		// Interval is how often bulbs are adjusted, and how long each adjustment is smoothed over.
+		Interval time.Duration
+	}
+
+	// Homie configures the Homie topic layout.
+	Homie struct {
+		// BaseTopic is the root of all Homie devices, usually "homie".
+		BaseTopic string
 	}
 
 	Config struct {
 		BrokerURI string
+		MQTT      MQTT
+
+		// AvailabilityTopic is the prefix for each daemon's availability, either "online" or "offline".
+		AvailabilityTopic string
+
+		Layout Layout
+		Homie  Homie
 
 		BulbsByLabel map[string]Bulb
+
+		// SceneTopic is where scene names are published to apply them.
+		SceneTopic   string
+		ScenesByName map[string]Scene
+
+		AlarmsByName map[string]Alarm
+
+		// HomeAssistant is nil if Home Assistant discovery is disabled.
+		HomeAssistant *HomeAssistant
+
+		// Adaptive is nil if no bulbs are adaptive.
+		Adaptive *Adaptive
+
+		Schedules []Schedule
+
+		// Location is nil if it is not configured.
+		Location *Location
+
+		// TopicTemplate is nil if every bulb's topics are configured explicitly.
+		TopicTemplate *TopicTemplate
+
+		// AutoConfigure is whether discovered bulbs that are not in BulbsByLabel are bridged too, using AutoBulb.
+		AutoConfigure bool
 	}
 
 	config struct {
-		MQTTBroker string `json:"mqttBroker"`
-		Bulbs      map[string]struct {
-			Label  string `json:"label"`
-			Topics struct {
-				Power      string `json:"power"`
-				Hue        string `json:"hue"`
-				Saturation string `json:"saturation"`
-				Brightness string `json:"brightness"`
-				Kelvin     string `json:"kelvin"`
-			} `json:"topics"`
-		} `json:"bulbs"`
+		MQTTBroker        string `json:"mqttBroker"`
+		MQTT              mqtt   `json:"mqtt"`
+		AvailabilityTopic string `json:"availabilityTopic"`
+		Layout            string `json:"layout"`
+		Homie             struct {
+			BaseTopic string `json:"baseTopic"`
+		} `json:"homie"`
+		HomeAssistant *struct {
+			DiscoveryPrefix string `json:"discoveryPrefix"`
+			TopicPrefix     string `json:"topicPrefix"`
+		} `json:"homeAssistant"`
+		Adaptive      *adaptive        `json:"adaptive"`
+		TopicTemplate *topicTemplate   `json:"topicTemplate"`
+		AutoConfigure bool             `json:"autoConfigure"`
+		Bulbs         map[string]bulb  `json:"bulbs"`
+		Groups        map[string]group `json:"groups"`
+		SceneTopic    string           `json:"sceneTopic"`
+		Scenes        map[string]scene `json:"scenes"`
+		Alarms        map[string]alarm `json:"alarms"`
+		Schedules     []schedule       `json:"schedules"`
+		Location      *Location        `json:"location"`
+	}
+
+	bulb struct {
+		Label          string            `json:"label"`
+		Topics         topics            `json:"topics"`
+		Variables      map[string]string `json:"variables"`
+		PollInterval   string            `json:"pollInterval"`
+		CoalesceWindow string            `json:"coalesceWindow"`
+		Adaptive       bool              `json:"adaptive"`
+	}
+	topics struct {
+		Power      string `json:"power"`
+		Hue        string `json:"hue"`
+		Saturation string `json:"saturation"`
+		Brightness string `json:"brightness"`
+		Kelvin     string `json:"kelvin"`
+
+		Availability string `json:"availability,omitempty"`
+		Effect       string `json:"effect,omitempty"`
+	}
+	topicTemplate struct {
+		Template     string            `json:"template"`
+		Variables    map[string]string `json:"variables"`
+		Availability bool              `json:"availability"`
+		Effect       bool              `json:"effect"`
+	}
+	group struct {
+		bulb
+		Members []string `json:"bulbs"`
+	}
+
+	adaptive struct {
+		Latitude      *float64 `json:"latitude"`
+		Longitude     *float64 `json:"longitude"`
+		MinKelvin     int      `json:"minKelvin"`
+		MaxKelvin     int      `json:"maxKelvin"`
+		MinBrightness int      `json:"minBrightness"`
+		MaxBrightness int      `json:"maxBrightness"`
+		Interval      string   `json:"interval"`
+	}
+
+	schedule struct {
+		When       string `json:"when"`
+		CatchUp    string `json:"catchUp"`
+		Scene      string `json:"scene"`
+		Bulb       string `json:"bulb"`
+		Power      string `json:"power"`
+		Hue        *int   `json:"hue"`
+		Saturation *int   `json:"saturation"`
+		Brightness *int   `json:"brightness"`
+		Kelvin     *int   `json:"kelvin"`
+		Transition string `json:"transition"`
+	}
+
+	alarm struct {
+		Bulbs    []string `json:"bulbs"`
+		Duration string   `json:"duration"`
+		Topic    string   `json:"topic"`
+		Time     string   `json:"time"`
+		Days     []string `json:"days"`
+	}
+
+	scene struct {
+		Transition string               `json:"transition"`
+		Bulbs      map[string]sceneBulb `json:"bulbs"`
+	}
+	sceneBulb struct {
+		Power      string `json:"power"`
+		Hue        int    `json:"hue"`
+		Saturation int    `json:"saturation"`
+		Brightness int    `json:"brightness"`
+		Kelvin     int    `json:"kelvin"`
 	}
 )
 
+// Availability payloads.
+const (
+	Online  = "online"
+	Offline = "offline"
+)
+
+// BridgeAvailability returns the Availability of a daemon, e.g. at "catbus-lifx/observer".
+func (c *Config) BridgeAvailability(daemon string) Availability {
+	return Availability{
+		Topic:   c.AvailabilityTopic + "/" + daemon,
+		Online:  Online,
+		Offline: Offline,
+	}
+}
+
+// BulbAvailability returns the Availability of a bulb, if it has one.
+// For the Homie layout, this is the device's $state.
+func (c *Config) BulbAvailability(b Bulb) (Availability, bool) {
+	if c.Layout == LayoutHomie {
+		return Availability{
+			Topic:   homie.StateTopic(c.Homie.BaseTopic, homie.DeviceID(b.Label)),
+			Online:  homie.StateReady,
+			Offline: homie.StateLost,
+		}, true
+	}
+
+	topic := b.Topics.Availability
+	return Availability{
+		Topic:   topic,
+		Online:  Online,
+		Offline: Offline,
+	}, topic != ""
+}
+
+// Next returns the next time the alarm finishes after a given time, in that time's location.
+func (s *AlarmSchedule) Next(after time.Time) time.Time {
+	year, month, day := after.Date()
+	for i := 0; i <= 7; i++ {
+		t := time.Date(year, month, day+i, s.Hour, s.Minute, 0, 0, after.Location())
+		if !t.After(after) {
+			continue
+		}
+		for _, d := range s.Days {
+			if t.Weekday() == d {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// EffectTopic returns where a bulb's software effect is published, if anywhere.
+// For the Homie layout, this is the device's effect property.
+func (c *Config) EffectTopic(b Bulb) (string, bool) {
+	if c.Layout == LayoutHomie {
+		return homie.PropertyTopic(c.Homie.BaseTopic, homie.DeviceID(b.Label), homie.PropertyEffect), true
+	}
+
+	topic := b.Topics.Effect
+	return topic, topic != ""
+}
+
+// AutoBulb returns the config for a discovered bulb that is not in BulbsByLabel, with the default settings and topics from the TopicTemplate.
+// The variables are usually "mac" and "group", and any the template needs that are missing are an error.
+func (c *Config) AutoBulb(label string, variables map[string]string) (Bulb, error) {
+	b := Bulb{
+		Label:          label,
+		PollInterval:   DefaultPollInterval,
+		CoalesceWindow: DefaultCoalesceWindow,
+	}
+	if c.Layout == LayoutHomie {
+		return b, nil
+	}
+
+	topics, err := c.TopicTemplate.Topics(label, variables)
+	if err != nil {
+		return Bulb{}, err
+	}
+	b.Topics = topics
+	return b, nil
+}
+
+// ParseFile parses a config file, in the format given by its extension, with any overrides from the environment.
+// It returns Problems if it is not a valid config.
 func ParseFile(path string) (*Config, error) {
-	bytes, err := ioutil.ReadFile(path)
+	values, err := ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	var problems Problems
+	for _, name := range overrideFromEnv(values, os.Environ(), &problems) {
+		// Unlike unknown fields in the file, these may be set for something else, e.g. an older or newer version.
+		log := logger.Background()
+		log.AddField("variable", name)
+		log.Warning("ignoring environment variable that is not a known field")
+	}
 
-	raw := config{}
-	if err := json.Unmarshal(bytes, &raw); err != nil {
+	data, err := json.Marshal(values)
+	if err != nil {
 		return nil, err
 	}
+	return parse(data, problems)
+}
 
-	return configFromConfig(raw), nil
+// Parse parses a JSON config, returning Problems if it is not a valid config.
+// Unknown fields are problems, so that typos are not silently ignored.
+func Parse(data []byte) (*Config, error) {
+	return parse(data, nil)
 }
 
-func configFromConfig(raw config) *Config {
+func parse(data []byte, problems Problems) (*Config, error) {
+	unknownFields("", data, reflect.TypeOf(config{}), &problems)
+
+	raw := config{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return nil, syntaxError(data, err)
+		}
+		// Unmarshal skips fields of the wrong type and carries on, so the rest can still be checked.
+	}
+	var typeProblems Problems
+	wrongTypes("", data, reflect.TypeOf(config{}), &typeProblems)
+
+	c := configFromConfig(raw, &problems)
+	// Fields of the wrong type were left empty, so they are not also reported as e.g. required.
+	problems = append(withoutFieldsOf(problems, typeProblems), typeProblems...)
+	if len(problems) > 0 {
+		sort.SliceStable(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+		return nil, problems
+	}
+	return c, nil
+}
+
+func configFromConfig(raw config, problems *Problems) *Config {
 	c := &Config{
-		BrokerURI:    raw.MQTTBroker,
-		BulbsByLabel: map[string]Bulb{},
+		BrokerURI:         raw.MQTTBroker,
+		AvailabilityTopic: raw.AvailabilityTopic,
+		Layout:            Layout(raw.Layout),
+		Homie:             Homie(raw.Homie),
+		BulbsByLabel:      map[string]Bulb{},
+		SceneTopic:        raw.SceneTopic,
+		ScenesByName:      map[string]Scene{},
+		AlarmsByName:      map[string]Alarm{},
+		AutoConfigure:     raw.AutoConfigure,
+	}
+
+	if c.BrokerURI == "" {
+		problems.add("mqttBroker", "is required")
+	}
+	c.MQTT = mqttFromMQTT("mqtt", raw.MQTT, problems)
+	switch c.Layout {
+	case "":
+		c.Layout = LayoutCatbus
+	case LayoutCatbus, LayoutHomie:
+	default:
+		problems.add("layout", "must be %q or %q, found %q", LayoutCatbus, LayoutHomie, raw.Layout)
+	}
+	if c.Homie.BaseTopic == "" {
+		c.Homie.BaseTopic = "homie"
+	}
+	if c.AvailabilityTopic == "" {
+		c.AvailabilityTopic = "catbus-lifx"
+	}
+	if c.SceneTopic == "" {
+		c.SceneTopic = "catbus-lifx/scene"
+	}
+
+	if raw.HomeAssistant != nil {
+		ha := HomeAssistant(*raw.HomeAssistant)
+		if ha.DiscoveryPrefix == "" {
+			ha.DiscoveryPrefix = "homeassistant"
+		}
+		if ha.TopicPrefix == "" {
+			ha.TopicPrefix = "catbus-lifx"
+		}
+		c.HomeAssistant = &ha
 	}
 
-	for k, v := range raw.Bulbs {
-		label := k
-		if v.Label != "" {
-			label = v.Label
+	if raw.Location != nil {
+		validateLocation("location", *raw.Location, problems)
+		c.Location = raw.Location
+	}
+
+	if raw.Adaptive != nil {
+		a := adaptiveFromAdaptive("adaptive", *raw.Adaptive, c.Location, problems)
+		c.Adaptive = &a
+	}
+
+	if raw.TopicTemplate != nil {
+		c.TopicTemplate = topicTemplateFromTopicTemplate("topicTemplate", *raw.TopicTemplate, problems)
+	}
+	// Homie topics are derived from labels instead.
+	template := c.TopicTemplate
+	if c.Layout == LayoutHomie {
+		template = nil
+	} else if c.AutoConfigure && template == nil {
+		problems.add("autoConfigure", "needs a topicTemplate, in the catbus layout")
+	}
+
+	// pathsByLabel are where each bulb and group is configured, for reporting problems that refer to them.
+	pathsByLabel := map[string]string{}
+	for _, k := range sortedKeys(raw.Bulbs) {
+		path := key("bulbs", k)
+		b := bulbFromBulb(path, k, raw.Bulbs[k], template, problems)
+		if other, ok := pathsByLabel[b.Label]; ok {
+			problems.add(path, "has the same label as %v", other)
+			continue
 		}
+		pathsByLabel[b.Label] = path
+		c.BulbsByLabel[b.Label] = b
+	}
 
-		b := Bulb(v)
-		b.Label = label
+	// Groups are configured like bulbs, and can be controlled like bulbs, but are made of other bulbs.
+	for _, k := range sortedKeys(raw.Groups) {
+		path := key("groups", k)
+		v := raw.Groups[k]
+		b := bulbFromBulb(path, k, v.bulb, template, problems)
+		if other, ok := pathsByLabel[b.Label]; ok {
+			problems.add(path, "has the same label as %v", other)
+			continue
+		}
+		if len(v.Members) == 0 {
+			problems.add(field(path, "bulbs"), "must not be empty")
+		}
+		for i, member := range v.Members {
+			if m, ok := c.BulbsByLabel[member]; !ok || len(m.Members) > 0 {
+				problems.add(index(field(path, "bulbs"), i), "is not a configured bulb, found %q", member)
+			}
+		}
+		b.Members = v.Members
+		pathsByLabel[b.Label] = path
+		c.BulbsByLabel[b.Label] = b
+	}
 
-		c.BulbsByLabel[label] = b
+	for label, b := range c.BulbsByLabel {
+		if b.Adaptive && c.Adaptive == nil {
+			problems.add(field(pathsByLabel[label], "adaptive"), "needs an adaptive config")
+		}
+		if c.Layout == LayoutCatbus {
+			validateTopics(field(pathsByLabel[label], "topics"), b.Topics, problems)
+		}
 	}
 
+	for _, name := range sortedKeys(raw.Scenes) {
+		path := key("scenes", name)
+		scene := sceneFromScene(path, name, raw.Scenes[name], problems)
+		for label := range scene.StatesByLabel {
+			if _, ok := c.BulbsByLabel[label]; !ok {
+				problems.add(key(field(path, "bulbs"), label), "is not a configured bulb")
+			}
+		}
+		c.ScenesByName[name] = scene
+	}
+
+	for _, name := range sortedKeys(raw.Alarms) {
+		path := key("alarms", name)
+		alarm := alarmFromAlarm(path, name, raw.Alarms[name], problems)
+		for i, label := range alarm.Labels {
+			if _, ok := c.BulbsByLabel[label]; !ok {
+				problems.add(index(field(path, "bulbs"), i), "is not a configured bulb, found %q", label)
+			}
+		}
+		c.AlarmsByName[name] = alarm
+	}
+
+	for i, v := range raw.Schedules {
+		path := index("schedules", i)
+		schedule := scheduleFromSchedule(path, v, c.Location, problems)
+		if _, ok := c.ScenesByName[schedule.Scene]; schedule.Scene != "" && !ok {
+			problems.add(field(path, "scene"), "is not a configured scene, found %q", schedule.Scene)
+		}
+		if _, ok := c.BulbsByLabel[schedule.Label]; schedule.Label != "" && !ok {
+			problems.add(field(path, "bulb"), "is not a configured bulb, found %q", schedule.Label)
+		}
+		c.Schedules = append(c.Schedules, schedule)
+	}
+
+	duplicateTopics(c, pathsByLabel, problems)
 	return c
 }
+
+func bulbFromBulb(path, k string, raw bulb, template *TopicTemplate, problems *Problems) Bulb {
+	label := k
+	if raw.Label != "" {
+		label = raw.Label
+	}
+
+	// Explicit topics override the template's.
+	if template != nil {
+		t, err := template.Topics(label, raw.Variables)
+		if err != nil {
+			problems.add(field(path, "variables"), "%v", err)
+		}
+		fill := func(topic *string, generated string) {
+			if *topic == "" {
+				*topic = generated
+			}
+		}
+		fill(&raw.Topics.Power, t.Power)
+		fill(&raw.Topics.Hue, t.Hue)
+		fill(&raw.Topics.Saturation, t.Saturation)
+		fill(&raw.Topics.Brightness, t.Brightness)
+		fill(&raw.Topics.Kelvin, t.Kelvin)
+		fill(&raw.Topics.Availability, t.Availability)
+		fill(&raw.Topics.Effect, t.Effect)
+	} else if len(raw.Variables) > 0 {
+		problems.add(field(path, "variables"), "need a topicTemplate, in the catbus layout")
+	}
+
+	b := Bulb{
+		Label:          label,
+		Topics:         Topics(raw.Topics),
+		PollInterval:   DefaultPollInterval,
+		CoalesceWindow: DefaultCoalesceWindow,
+		Adaptive:       raw.Adaptive,
+	}
+	if raw.PollInterval != "" {
+		b.PollInterval = parseDuration(field(path, "pollInterval"), raw.PollInterval, problems)
+	}
+	if raw.CoalesceWindow != "" {
+		b.CoalesceWindow = parseDuration(field(path, "coalesceWindow"), raw.CoalesceWindow, problems)
+	}
+	return b
+}
+
+// validateTopics checks that a bulb in the catbus layout has all of its required topics.
+func validateTopics(path string, t Topics, problems *Problems) {
+	required := []struct {
+		name, topic string
+	}{
+		{"power", t.Power},
+		{"hue", t.Hue},
+		{"saturation", t.Saturation},
+		{"brightness", t.Brightness},
+		{"kelvin", t.Kelvin},
+	}
+	for _, r := range required {
+		if r.topic == "" {
+			problems.add(field(path, r.name), "is required")
+		}
+	}
+}
+
+func validateLocation(path string, l Location, problems *Problems) {
+	if !(-90 <= l.Latitude && l.Latitude <= 90) {
+		problems.add(field(path, "latitude"), "must be within [-90,90], found %v", l.Latitude)
+	}
+	if !(-180 <= l.Longitude && l.Longitude <= 180) {
+		problems.add(field(path, "longitude"), "must be within [-180,180], found %v", l.Longitude)
+	}
+}
+
+// adaptiveFromAdaptive uses the config's location, unless the adaptive config has its own latitude and longitude.
+func adaptiveFromAdaptive(path string, raw adaptive, location *Location, problems *Problems) Adaptive {
+	if raw.Latitude != nil && raw.Longitude != nil {
+		location = &Location{Latitude: *raw.Latitude, Longitude: *raw.Longitude}
+		validateLocation(path, *location, problems)
+	}
+	if location == nil {
+		problems.add(path, "must have a latitude and longitude, or the config a location")
+		location = &Location{}
+	}
+	a := Adaptive{
+		Latitude:      location.Latitude,
+		Longitude:     location.Longitude,
+		MinKelvin:     raw.MinKelvin,
+		MaxKelvin:     raw.MaxKelvin,
+		MinBrightness: raw.MinBrightness,
+		MaxBrightness: raw.MaxBrightness,
+	}
+
+	if a.MinKelvin == 0 {
+		a.MinKelvin = 2700
+	}
+	if a.MaxKelvin == 0 {
+		a.MaxKelvin = 6000
+	}
+	if a.MinBrightness == 0 {
+		a.MinBrightness = 30
+	}
+	if a.MaxBrightness == 0 {
+		a.MaxBrightness = lifx.MaxBrightness
+	}
+	if err := (lifx.HSBK{Brightness: a.MinBrightness, Kelvin: a.MinKelvin}).Validate(); err != nil {
+		problems.add(path, "has %v", err)
+	} else if err := (lifx.HSBK{Brightness: a.MaxBrightness, Kelvin: a.MaxKelvin}).Validate(); err != nil {
+		problems.add(path, "has %v", err)
+	} else if a.MinKelvin > a.MaxKelvin || a.MinBrightness > a.MaxBrightness {
+		problems.add(path, "minimums must not be more than its maximums")
+	}
+
+	a.Interval = 5 * time.Minute
+	if raw.Interval != "" {
+		a.Interval = parsePositiveDuration(field(path, "interval"), raw.Interval, problems)
+	}
+	return a
+}
+
+func scheduleFromSchedule(path string, raw schedule, location *Location, problems *Problems) Schedule {
+	s := Schedule{
+		Scene:      raw.Scene,
+		Label:      raw.Bulb,
+		Hue:        raw.Hue,
+		Saturation: raw.Saturation,
+		Brightness: raw.Brightness,
+		Kelvin:     raw.Kelvin,
+	}
+
+	var err error
+	if cron.IsSolar(raw.When) {
+		if location == nil {
+			problems.add(field(path, "when"), "sunrise and sunset need the config to have a location")
+		} else {
+			s.When, err = cron.ParseSolar(raw.When, location.Latitude, location.Longitude)
+		}
+	} else {
+		s.When, err = cron.Parse(raw.When)
+	}
+	if err != nil {
+		problems.add(field(path, "when"), "%v", err)
+	}
+
+	if raw.CatchUp != "" {
+		s.CatchUp = parseDuration(field(path, "catchUp"), raw.CatchUp, problems)
+	}
+	if raw.Transition != "" {
+		s.Transition = parseDuration(field(path, "transition"), raw.Transition, problems)
+	}
+
+	if (s.Scene == "") == (s.Label == "") {
+		problems.add(path, "must have either a scene or a bulb")
+		return s
+	}
+	if s.Scene != "" {
+		if raw.Power != "" || s.Hue != nil || s.Saturation != nil || s.Brightness != nil || s.Kelvin != nil {
+			problems.add(path, "cannot change a bulb as well as applying a scene")
+		}
+		return s
+	}
+
+	switch raw.Power {
+	case "":
+	case "on":
+		power := lifx.On
+		s.Power = &power
+	case "off":
+		power := lifx.Off
+		s.Power = &power
+	default:
+		problems.add(field(path, "power"), "must be on or off, found %q", raw.Power)
+	}
+	if raw.Power == "" && s.Hue == nil && s.Saturation == nil && s.Brightness == nil && s.Kelvin == nil {
+		problems.add(path, "must change at least one of power, hue, saturation, brightness, or kelvin")
+	}
+
+	// Check each part that is set against a color that is otherwise valid.
+	color := lifx.HSBK{Kelvin: lifx.MinKelvin}
+	if s.Hue != nil {
+		color.Hue = *s.Hue
+	}
+	if s.Saturation != nil {
+		color.Saturation = *s.Saturation
+	}
+	if s.Brightness != nil {
+		color.Brightness = *s.Brightness
+	}
+	if s.Kelvin != nil {
+		color.Kelvin = *s.Kelvin
+	}
+	if err := color.Validate(); err != nil {
+		problems.add(path, "has %v", err)
+	}
+	return s
+}
+
+// weekdays are the days of the week, as abbreviated in config files.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func alarmFromAlarm(path, name string, raw alarm, problems *Problems) Alarm {
+	a := Alarm{
+		Name:     name,
+		Labels:   raw.Bulbs,
+		Duration: 30 * time.Minute,
+		Topic:    raw.Topic,
+	}
+	if len(a.Labels) == 0 {
+		problems.add(field(path, "bulbs"), "must not be empty")
+	}
+	if raw.Duration != "" {
+		a.Duration = parsePositiveDuration(field(path, "duration"), raw.Duration, problems)
+	}
+
+	if raw.Time == "" {
+		if len(raw.Days) > 0 {
+			problems.add(field(path, "days"), "needs a time")
+		}
+		if a.Topic == "" {
+			problems.add(path, "must have a time, a topic, or both")
+		}
+		return a
+	}
+
+	t, err := time.Parse("15:04", raw.Time)
+	if err != nil {
+		problems.add(field(path, "time"), "must be like 07:30, found %q", raw.Time)
+	}
+	schedule := &AlarmSchedule{
+		Hour:   t.Hour(),
+		Minute: t.Minute(),
+	}
+	for i, day := range raw.Days {
+		weekday, ok := weekdays[day]
+		if !ok {
+			problems.add(index(field(path, "days"), i), "must be sun, mon, tue, wed, thu, fri, or sat, found %q", day)
+			continue
+		}
+		schedule.Days = append(schedule.Days, weekday)
+	}
+	if len(schedule.Days) == 0 {
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			schedule.Days = append(schedule.Days, d)
+		}
+	}
+	a.Schedule = schedule
+	return a
+}
+
+func sceneFromScene(path, name string, raw scene, problems *Problems) Scene {
+	s := Scene{
+		Name:          name,
+		StatesByLabel: map[string]lifx.State{},
+	}
+	if raw.Transition != "" {
+		s.Transition = parseDuration(field(path, "transition"), raw.Transition, problems)
+	}
+
+	for label, v := range raw.Bulbs {
+		path := key(field(path, "bulbs"), label)
+		state := lifx.State{
+			Label: label,
+			Color: lifx.HSBK{
+				Hue:        v.Hue,
+				Saturation: v.Saturation,
+				Brightness: v.Brightness,
+				Kelvin:     v.Kelvin,
+			},
+		}
+		switch v.Power {
+		case "on":
+			state.Power = lifx.On
+		case "off":
+			state.Power = lifx.Off
+		default:
+			problems.add(field(path, "power"), "must be on or off, found %q", v.Power)
+		}
+		if err := state.Color.Validate(); err != nil {
+			problems.add(path, "has %v", err)
+		}
+		s.StatesByLabel[label] = state
+	}
+	return s
+}
+
+// MarshalJSON returns the topics as they would appear in a config file's bulb "topics".
+func (t Topics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(topics(t))
+}
+
+// MarshalJSON returns the scene as it would appear in a config file's "scenes".
+func (s Scene) MarshalJSON() ([]byte, error) {
+	raw := scene{
+		Transition: s.Transition.String(),
+		Bulbs:      map[string]sceneBulb{},
+	}
+	for label, state := range s.StatesByLabel {
+		raw.Bulbs[label] = sceneBulb{
+			Power:      state.Power.String(),
+			Hue:        state.Color.Hue,
+			Saturation: state.Color.Saturation,
+			Brightness: state.Color.Brightness,
+			Kelvin:     state.Color.Kelvin,
+		}
+	}
+	return json.Marshal(raw)
+}
@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProblems(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want Problems
+	}{
+		{
+			raw: `{"mqttBroker": "tcp://localhost:1883", "mqttBrokr": "tcp://localhost:1883"}`,
+			want: Problems{
+				{Path: "mqttBrokr", Message: "is not a known field"},
+			},
+		},
+		{
+			raw: `{"mqttBroker": "tcp://localhost:1883", "layout": "homie", "bulbs": {"Bedside Lamp": {"topics": {"powr": "lamp/power"}}}}`,
+			want: Problems{
+				{Path: `bulbs["Bedside Lamp"].topics.powr`, Message: "is not a known field"},
+			},
+		},
+		{
+			// Every value of the wrong type is reported, not just the first, and not also as required.
+			raw: `{"mqttBroker": 1883, "availabilityTopic": true, "layout": "homie", "bulbs": {"Bedside Lamp": {"pollInterval": 30}}}`,
+			want: Problems{
+				{Path: "availabilityTopic", Message: "must be a string, found bool"},
+				{Path: `bulbs["Bedside Lamp"].pollInterval`, Message: "must be a string, found number"},
+				{Path: "mqttBroker", Message: "must be a string, found number"},
+			},
+		},
+		{
+			raw: `{"mqttBroker": "tcp://localhost:1883", "bulbs": []}`,
+			want: Problems{
+				{Path: "bulbs", Message: "must be an object, found array"},
+			},
+		},
+		{
+			raw: `{
+				"mqttBroker": "tcp://localhost:1883",
+				"bulbs": {
+					"Bedside Lamp": {"topics": {"power": "lamp/power", "hue": "lamp/hue", "saturation": "lamp/saturation", "brightness": "lamp/brightness", "kelvin": "lamp/kelvin"}},
+					"Desk Lamp": {"topics": {"power": "lamp/power", "hue": "desk/hue", "saturation": "desk/saturation", "brightness": "desk/brightness", "kelvin": "desk/kelvin"}}
+				}
+			}`,
+			want: Problems{
+				{Path: `bulbs["Desk Lamp"].topics.power`, Message: `topic "lamp/power" is also used by bulbs["Bedside Lamp"].topics.power`},
+			},
+		},
+		{
+			raw: `{"mqttBroker": "tcp://localhost:1883", "bulbs": {"Bedside Lamp": {"topics": {"power": "lamp/power"}}}}`,
+			want: Problems{
+				{Path: `bulbs["Bedside Lamp"].topics.brightness`, Message: "is required"},
+				{Path: `bulbs["Bedside Lamp"].topics.hue`, Message: "is required"},
+				{Path: `bulbs["Bedside Lamp"].topics.kelvin`, Message: "is required"},
+				{Path: `bulbs["Bedside Lamp"].topics.saturation`, Message: "is required"},
+			},
+		},
+		{
+			raw: `{"mqttBroker": "tcp://localhost:1883", "layout": "homie", "bulbs": {"Bedside Lamp": {"pollInterval": "soon"}}, "schedules": [{"when": "0 7 * * *", "bulb": "Desk Lamp", "power": "on"}]}`,
+			want: Problems{
+				{Path: `bulbs["Bedside Lamp"].pollInterval`, Message: `must be a duration like 1m30s, found "soon"`},
+				{Path: "schedules[0].bulb", Message: `is not a configured bulb, found "Desk Lamp"`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.raw))
+		var got Problems
+		if !errors.As(err, &got) {
+			t.Errorf("Parse(%s) = %v, want Problems", tt.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%s) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	_, err := Parse([]byte("{\n\"mqttBroker\": \"tcp://localhost:1883\",\n}"))
+	if want := "invalid JSON on line 3"; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("Parse = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,243 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the syntax of a config file.
+type Format string
+
+const (
+	FormatJSON = Format("json")
+	FormatYAML = Format("yaml")
+	FormatTOML = Format("toml")
+)
+
+// EnvPrefix prefixes the environment variables that override fields of a config file.
+// Each field's variable is its path in upper snake case, e.g. CATBUS_LIFX_MQTT_BROKER for mqttBroker, or CATBUS_LIFX_HOME_ASSISTANT_TOPIC_PREFIX for homeAssistant.topicPrefix.
+const EnvPrefix = "CATBUS_LIFX_"
+
+// FormatForPath returns the Format of a config file from its extension, which is JSON unless it is .yaml, .yml, or .toml.
+func FormatForPath(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+// ReadFile returns the fields of a config file as JSON values, without checking them or applying environment variables, e.g. to edit the file.
+func ReadFile(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decode(FormatForPath(path), data)
+}
+
+// Encode returns the fields of a config file, as returned by ReadFile, in a Format.
+func Encode(format Format, values map[string]interface{}) ([]byte, error) {
+	switch format {
+	case FormatYAML:
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(values); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case FormatTOML:
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(values); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	default:
+		data, err := json.MarshalIndent(values, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+}
+
+// decode decodes a config file into the values JSON would, so that every format is checked and parsed the same way.
+func decode(format Format, data []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	switch format {
+	case FormatYAML:
+		var v interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+		if v == nil {
+			return values, nil
+		}
+		m, ok := jsonValue(v).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid YAML: must be a mapping, found %T", v)
+		}
+		return m, nil
+
+	case FormatTOML:
+		if _, err := toml.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+			return nil, fmt.Errorf("invalid TOML: %w", err)
+		}
+		return values, nil
+
+	default:
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, syntaxError(data, err)
+		}
+		return values, nil
+	}
+}
+
+// jsonValue converts YAML's maps, which can have keys of any type, into JSON objects.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = jsonValue(e)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// overrideFromEnv sets the values of fields that have an environment variable, e.g. "CATBUS_LIFX_MQTT_BROKER=tcp://broker:1883".
+// Only fields outside of maps and lists can be overridden, so not those of individual bulbs.
+// It returns the variables with the prefix that are not a known field.
+func overrideFromEnv(values map[string]interface{}, environ []string, problems *Problems) (unknown []string) {
+	fieldsByEnv := map[string]envField{}
+	envFields(nil, reflect.TypeOf(config{}), fieldsByEnv)
+
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], EnvPrefix) {
+			continue
+		}
+		name, raw := parts[0], parts[1]
+
+		f, ok := fieldsByEnv[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+
+		var value interface{}
+		switch f.kind {
+		case reflect.Bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				problems.add(name, "must be true or false, found %q", raw)
+				continue
+			}
+			value = b
+		case reflect.Int, reflect.Float64:
+			n, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				problems.add(name, "must be a number, found %q", raw)
+				continue
+			}
+			value = n
+		default:
+			value = raw
+		}
+
+		// Objects along the path are created as needed, e.g. to enable Home Assistant from the environment.
+		m := values
+		for _, p := range f.path[:len(f.path)-1] {
+			next, ok := m[p].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				m[p] = next
+			}
+			m = next
+		}
+		m[f.path[len(f.path)-1]] = value
+	}
+	return unknown
+}
+
+// envField is a field of a config file that can be set from the environment.
+type envField struct {
+	path []string
+	kind reflect.Kind
+}
+
+// envFields adds the environment variable of every scalar field of a struct, and of the structs within it.
+func envFields(path []string, t reflect.Type, fieldsByEnv map[string]envField) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fieldPath := append(append([]string{}, path...), name)
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch ft.Kind() {
+		case reflect.Struct:
+			envFields(fieldPath, ft, fieldsByEnv)
+		case reflect.String, reflect.Bool, reflect.Int, reflect.Float64:
+			fieldsByEnv[envName(fieldPath)] = envField{path: fieldPath, kind: ft.Kind()}
+		}
+	}
+}
+
+// envName returns the environment variable for a field, e.g. CATBUS_LIFX_HOME_ASSISTANT_TOPIC_PREFIX for homeAssistant.topicPrefix.
+func envName(path []string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, p := range path {
+		if i > 0 {
+			b.WriteByte('_')
+		}
+		for j, r := range p {
+			if j > 0 && unicode.IsUpper(r) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
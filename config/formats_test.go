@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const formatsJSON = `{
+	"mqttBroker": "tcp://localhost:1883",
+	"homeAssistant": {"topicPrefix": "lights"},
+	"bulbs": {
+		"Bedside Lamp": {
+			"topics": {"power": "lamp/power", "hue": "lamp/hue", "saturation": "lamp/saturation", "brightness": "lamp/brightness", "kelvin": "lamp/kelvin"},
+			"pollInterval": "10s"
+		}
+	},
+	"scenes": {
+		"Reading": {"transition": "2s", "bulbs": {"Bedside Lamp": {"power": "on", "brightness": 80, "kelvin": 3500}}}
+	},
+	"schedules": [
+		{"when": "30 7 * * 1-5", "bulb": "Bedside Lamp", "power": "on", "brightness": 100}
+	]
+}`
+
+const formatsYAML = `
+mqttBroker: tcp://localhost:1883
+homeAssistant:
+  topicPrefix: lights
+bulbs:
+  Bedside Lamp:
+    topics:
+      power: lamp/power
+      hue: lamp/hue
+      saturation: lamp/saturation
+      brightness: lamp/brightness
+      kelvin: lamp/kelvin
+    pollInterval: 10s
+scenes:
+  Reading:
+    transition: 2s
+    bulbs:
+      Bedside Lamp: {power: "on", brightness: 80, kelvin: 3500}
+schedules:
+  - when: 30 7 * * 1-5
+    bulb: Bedside Lamp
+    power: "on"
+    brightness: 100
+`
+
+const formatsTOML = `
+mqttBroker = "tcp://localhost:1883"
+
+[homeAssistant]
+topicPrefix = "lights"
+
+[bulbs."Bedside Lamp"]
+pollInterval = "10s"
+
+[bulbs."Bedside Lamp".topics]
+power = "lamp/power"
+hue = "lamp/hue"
+saturation = "lamp/saturation"
+brightness = "lamp/brightness"
+kelvin = "lamp/kelvin"
+
+[scenes.Reading]
+transition = "2s"
+
+[scenes.Reading.bulbs."Bedside Lamp"]
+power = "on"
+brightness = 80
+kelvin = 3500
+
+[[schedules]]
+when = "30 7 * * 1-5"
+bulb = "Bedside Lamp"
+power = "on"
+brightness = 100
+`
+
+// parseFormat parses a config as ParseFile would, with the given environment.
+func parseFormat(format Format, raw string, environ []string) (*Config, []string, error) {
+	values, err := decode(format, []byte(raw))
+	if err != nil {
+		return nil, nil, err
+	}
+	var problems Problems
+	unknown := overrideFromEnv(values, environ, &problems)
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := parse(data, problems)
+	return c, unknown, err
+}
+
+func TestFormats(t *testing.T) {
+	want, err := Parse([]byte(formatsJSON))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	tests := []struct {
+		format Format
+		raw    string
+	}{
+		{format: FormatJSON, raw: formatsJSON},
+		{format: FormatYAML, raw: formatsYAML},
+		{format: FormatTOML, raw: formatsTOML},
+	}
+	for _, tt := range tests {
+		got, _, err := parseFormat(tt.format, tt.raw, nil)
+		if err != nil {
+			t.Errorf("%v: %v", tt.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %+v, want %+v", tt.format, got, want)
+		}
+	}
+}
+
+func TestFormatForPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want Format
+	}{
+		{path: "config.json", want: FormatJSON},
+		{path: "config.yaml", want: FormatYAML},
+		{path: "config.YML", want: FormatYAML},
+		{path: "config.toml", want: FormatTOML},
+		{path: "config", want: FormatJSON},
+	}
+	for _, tt := range tests {
+		if got := FormatForPath(tt.path); got != tt.want {
+			t.Errorf("FormatForPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOverrideFromEnv(t *testing.T) {
+	environ := []string{
+		"CATBUS_LIFX_MQTT_BROKER=tcp://broker:1883",
+		"CATBUS_LIFX_HOME_ASSISTANT_DISCOVERY_PREFIX=ha",
+		"CATBUS_LIFX_AUTO_CONFIGURE=false",
+		"CATBUS_LIFX_VERBOSE=true",
+		"HOME=/root",
+	}
+
+	for _, tt := range []struct {
+		format Format
+		raw    string
+	}{
+		{format: FormatJSON, raw: formatsJSON},
+		{format: FormatYAML, raw: formatsYAML},
+		{format: FormatTOML, raw: formatsTOML},
+	} {
+		c, unknown, err := parseFormat(tt.format, tt.raw, environ)
+		if err != nil {
+			t.Errorf("%v: %v", tt.format, err)
+			continue
+		}
+		if c.BrokerURI != "tcp://broker:1883" {
+			t.Errorf("%v: BrokerURI = %q, want the variable's", tt.format, c.BrokerURI)
+		}
+		// Objects in the file keep their other fields.
+		if c.HomeAssistant == nil || c.HomeAssistant.DiscoveryPrefix != "ha" || c.HomeAssistant.TopicPrefix != "lights" {
+			t.Errorf("%v: HomeAssistant = %+v, want prefixes \"ha\" and \"lights\"", tt.format, c.HomeAssistant)
+		}
+		if want := []string{"CATBUS_LIFX_VERBOSE"}; !reflect.DeepEqual(unknown, want) {
+			t.Errorf("%v: unknown variables = %q, want %q", tt.format, unknown, want)
+		}
+	}
+}
+
+func TestOverrideFromEnvProblems(t *testing.T) {
+	_, _, err := parseFormat(FormatJSON, formatsJSON, []string{"CATBUS_LIFX_AUTO_CONFIGURE=sometimes"})
+	want := Problems{{Path: "CATBUS_LIFX_AUTO_CONFIGURE", Message: `must be true or false, found "sometimes"`}}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("got %v, want %v", err, want)
+	}
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+type (
+	// MQTT configures the connection to the broker, beyond its URI.
+	MQTT struct {
+		// ClientID is empty for the client's default.
+		ClientID string
+		// KeepAlive is how often the connection is checked, or 0 for the client's default.
+		KeepAlive time.Duration
+
+		Username string
+		Password string
+
+		// TLS is nil for the client's default, which verifies ssl:// and tls:// brokers against the system's CAs.
+		TLS *tls.Config
+	}
+
+	mqtt struct {
+		ClientID     string   `json:"clientId"`
+		KeepAlive    string   `json:"keepAlive"`
+		Username     string   `json:"username"`
+		Password     string   `json:"password"`
+		PasswordFile string   `json:"passwordFile"`
+		TLS          *mqttTLS `json:"tls"`
+	}
+	mqttTLS struct {
+		CAFile             string `json:"caFile"`
+		CertFile           string `json:"certFile"`
+		KeyFile            string `json:"keyFile"`
+		ServerName         string `json:"serverName"`
+		InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+	}
+)
+
+// mqttFromMQTT reads any password, CA bundle, and client certificate from their files.
+func mqttFromMQTT(path string, raw mqtt, problems *Problems) MQTT {
+	m := MQTT{
+		ClientID: raw.ClientID,
+		Username: raw.Username,
+		Password: raw.Password,
+	}
+
+	if raw.KeepAlive != "" {
+		m.KeepAlive = parsePositiveDuration(field(path, "keepAlive"), raw.KeepAlive, problems)
+	}
+
+	if raw.PasswordFile != "" {
+		if raw.Password != "" {
+			problems.add(field(path, "passwordFile"), "cannot be set with password")
+		}
+		bytes, err := ioutil.ReadFile(raw.PasswordFile)
+		if err != nil {
+			problems.add(field(path, "passwordFile"), "could not be read: %v", err)
+		}
+		// Files written by editors and by echo usually end with a newline, which is never part of the password.
+		m.Password = strings.TrimRight(string(bytes), "\r\n")
+	}
+	if m.Password != "" && m.Username == "" {
+		problems.add(field(path, "username"), "is required with a password")
+	}
+
+	if raw.TLS != nil {
+		m.TLS = tlsFromTLS(field(path, "tls"), *raw.TLS, problems)
+	}
+	return m
+}
+
+func tlsFromTLS(path string, raw mqttTLS, problems *Problems) *tls.Config {
+	t := &tls.Config{
+		ServerName:         raw.ServerName,
+		InsecureSkipVerify: raw.InsecureSkipVerify,
+	}
+
+	if raw.CAFile != "" {
+		bytes, err := ioutil.ReadFile(raw.CAFile)
+		if err != nil {
+			problems.add(field(path, "caFile"), "could not be read: %v", err)
+		} else {
+			t.RootCAs = x509.NewCertPool()
+			if !t.RootCAs.AppendCertsFromPEM(bytes) {
+				problems.add(field(path, "caFile"), "has no PEM certificates")
+			}
+		}
+	}
+
+	switch {
+	case raw.CertFile != "" && raw.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(raw.CertFile, raw.KeyFile)
+		if err != nil {
+			problems.add(field(path, "certFile"), "could not be loaded with keyFile: %v", err)
+		} else {
+			t.Certificates = []tls.Certificate{cert}
+		}
+	case raw.CertFile != "":
+		problems.add(field(path, "keyFile"), "is required with certFile")
+	case raw.KeyFile != "":
+		problems.add(field(path, "certFile"), "is required with keyFile")
+	}
+	return t
+}
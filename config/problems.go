@@ -0,0 +1,297 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// Problem is something wrong with a config, at a JSON path such as `bulbs["Bedside Lamp"].topics.power`.
+	Problem struct {
+		Path    string
+		Message string
+	}
+
+	// Problems are everything wrong with a config.
+	Problems []Problem
+)
+
+func (p Problem) Error() string {
+	if p.Path == "" {
+		return p.Message
+	}
+	return p.Path + ": " + p.Message
+}
+
+func (p Problems) Error() string {
+	var lines []string
+	for _, problem := range p {
+		lines = append(lines, problem.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *Problems) add(path, format string, args ...interface{}) {
+	*p = append(*p, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// field returns the path of a field of an object.
+func field(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// key returns the path of a value in a map, whose keys are often labels with spaces.
+func key(path, k string) string {
+	return fmt.Sprintf("%v[%q]", path, k)
+}
+
+// index returns the path of an element of an array.
+func index(path string, i int) string {
+	return fmt.Sprintf("%v[%d]", path, i)
+}
+
+// sortedKeys returns the keys of a map with string keys, so that problems are found in a stable order.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func parseDuration(path, raw string, problems *Problems) time.Duration {
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		problems.add(path, "must be a duration like 1m30s, found %q", raw)
+	}
+	return d
+}
+
+func parsePositiveDuration(path, raw string, problems *Problems) time.Duration {
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		problems.add(path, "must be a duration like 1m30s, found %q", raw)
+	} else if d <= 0 {
+		problems.add(path, "must be positive, found %v", d)
+	}
+	return d
+}
+
+// syntaxError adds the line number to a JSON syntax error.
+func syntaxError(data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return err
+	}
+	line := 1 + bytes.Count(data[:syntaxErr.Offset], []byte("\n"))
+	return fmt.Errorf("invalid JSON on line %d: %w", line, err)
+}
+
+// unknownFields adds a Problem for every field of a JSON object that does not exist in the corresponding struct of t.
+// Values of the wrong type are skipped, as they are reported by wrongTypes.
+func unknownFields(path string, data json.RawMessage, t reflect.Type, problems *Problems) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		unknownFields(path, data, t.Elem(), problems)
+
+	case reflect.Slice:
+		var elems []json.RawMessage
+		if err := json.Unmarshal(data, &elems); err != nil {
+			return
+		}
+		for i, elem := range elems {
+			unknownFields(index(path, i), elem, t.Elem(), problems)
+		}
+
+	case reflect.Map:
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(data, &values); err != nil {
+			return
+		}
+		for k, v := range values {
+			unknownFields(key(path, k), v, t.Elem(), problems)
+		}
+
+	case reflect.Struct:
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(data, &values); err != nil {
+			return
+		}
+		fieldsByName := map[string]reflect.Type{}
+		structFields(t, fieldsByName)
+	values:
+		for name, v := range values {
+			// Like Unmarshal, match field names case-insensitively.
+			for n, ft := range fieldsByName {
+				if strings.EqualFold(n, name) {
+					unknownFields(field(path, name), v, ft, problems)
+					continue values
+				}
+			}
+			problems.add(field(path, name), "is not a known field")
+		}
+	}
+}
+
+// wrongTypes adds a Problem for every value of a JSON document that is the wrong type for the corresponding part of t.
+// Unlike Unmarshal, it reports every one, at a path such as `bulbs["Bedside Lamp"].pollInterval`.
+func wrongTypes(path string, data json.RawMessage, t reflect.Type, problems *Problems) {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		wrongTypes(path, data, t.Elem(), problems)
+
+	case reflect.Slice:
+		var elems []json.RawMessage
+		if err := json.Unmarshal(data, &elems); err != nil {
+			problems.add(path, "must be an array, found %v", jsonType(err))
+			return
+		}
+		for i, elem := range elems {
+			wrongTypes(index(path, i), elem, t.Elem(), problems)
+		}
+
+	case reflect.Map:
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(data, &values); err != nil {
+			problems.add(path, "must be an object, found %v", jsonType(err))
+			return
+		}
+		for k, v := range values {
+			wrongTypes(key(path, k), v, t.Elem(), problems)
+		}
+
+	case reflect.Struct:
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(data, &values); err != nil {
+			problems.add(path, "must be an object, found %v", jsonType(err))
+			return
+		}
+		fieldsByName := map[string]reflect.Type{}
+		structFields(t, fieldsByName)
+		for name, v := range values {
+			for n, ft := range fieldsByName {
+				if strings.EqualFold(n, name) {
+					wrongTypes(field(path, name), v, ft, problems)
+					break
+				}
+			}
+		}
+
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Float64:
+		if err := json.Unmarshal(data, reflect.New(t).Interface()); err != nil {
+			problems.add(path, "must be %v, found %v", kindName(t.Kind()), jsonType(err))
+		}
+	}
+}
+
+// jsonType returns the type of JSON value that could not be unmarshaled, e.g. "number" or "string".
+func jsonType(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return typeErr.Value
+	}
+	return "invalid JSON"
+}
+
+func kindName(k reflect.Kind) string {
+	switch k {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	default:
+		return "a number"
+	}
+}
+
+// withoutFieldsOf returns the problems that are not at or below any of the paths of others, e.g. the "is required" of a field of the wrong type.
+func withoutFieldsOf(problems, others Problems) Problems {
+	var kept Problems
+problems:
+	for _, p := range problems {
+		for _, o := range others {
+			if p.Path == o.Path || strings.HasPrefix(p.Path, o.Path+".") || strings.HasPrefix(p.Path, o.Path+"[") {
+				continue problems
+			}
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// structFields adds the JSON names and types of a struct's fields, including those of embedded structs.
+func structFields(t reflect.Type, fieldsByName map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			structFields(f.Type, fieldsByName)
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fieldsByName[name] = f.Type
+	}
+}
+
+// duplicateTopics adds a Problem for every topic that is used for more than one thing.
+func duplicateTopics(c *Config, pathsByLabel map[string]string, problems *Problems) {
+	type use struct {
+		path, topic string
+	}
+	var uses []use
+	if c.Layout == LayoutCatbus {
+		for label, b := range c.BulbsByLabel {
+			path := field(pathsByLabel[label], "topics")
+			uses = append(uses,
+				use{field(path, "power"), b.Topics.Power},
+				use{field(path, "hue"), b.Topics.Hue},
+				use{field(path, "saturation"), b.Topics.Saturation},
+				use{field(path, "brightness"), b.Topics.Brightness},
+				use{field(path, "kelvin"), b.Topics.Kelvin},
+				use{field(path, "availability"), b.Topics.Availability},
+				use{field(path, "effect"), b.Topics.Effect},
+			)
+		}
+	}
+	for name, a := range c.AlarmsByName {
+		uses = append(uses, use{field(key("alarms", name), "topic"), a.Topic})
+	}
+	if len(c.ScenesByName) > 0 {
+		uses = append(uses, use{"sceneTopic", c.SceneTopic})
+	}
+
+	sort.Slice(uses, func(i, j int) bool { return uses[i].path < uses[j].path })
+	pathsByTopic := map[string]string{}
+	for _, u := range uses {
+		if u.topic == "" {
+			continue
+		}
+		if other, ok := pathsByTopic[u.topic]; ok {
+			problems.add(u.path, "topic %q is also used by %v", u.topic, other)
+			continue
+		}
+		pathsByTopic[u.topic] = u.path
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.eth.moe/catbus-lifx/homie"
+	"go.eth.moe/catbus-lifx/lifx"
+)
+
+// templateVariables match the variables in a TopicTemplate, e.g. "{room}".
+var templateVariables = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// Topics returns the topics for a bulb, with the given variables overriding the template's defaults.
+// The variables "label", "name", and "field" are built in, and "name" defaults to the label as a Homie ID, e.g. "bedside-lamp".
+// Missing variables are an error, but are also expanded as empty, so that the topics can still be checked.
+func (t *TopicTemplate) Topics(label string, variables map[string]string) (Topics, error) {
+	values := map[string]string{
+		"label": label,
+		"name":  homie.DeviceID(label),
+	}
+	for k, v := range t.Variables {
+		values[k] = v
+	}
+	for k, v := range variables {
+		values[k] = v
+	}
+
+	var missing []string
+	expand := func(f string) string {
+		values["field"] = f
+		return templateVariables.ReplaceAllStringFunc(t.Template, func(v string) string {
+			name := v[1 : len(v)-1]
+			value, ok := values[name]
+			if !ok && !contains(missing, name) {
+				missing = append(missing, name)
+			}
+			return value
+		})
+	}
+
+	topics := Topics{
+		Power:      expand("power"),
+		Hue:        expand("hue"),
+		Saturation: expand("saturation"),
+		Brightness: expand("brightness"),
+		Kelvin:     expand("kelvin"),
+	}
+	if t.Availability {
+		topics.Availability = expand("availability")
+	}
+	if t.Effect {
+		topics.Effect = expand("effect")
+	}
+	if len(missing) > 0 {
+		return topics, fmt.Errorf("must set {%v} for the topic template", strings.Join(missing, "}, {"))
+	}
+	return topics, nil
+}
+
+// DiscoveredVariables returns the variables for a discovered bulb's topics.
+// "mac" is its MAC address without colons, and "group" is its group in the Lifx app as a Homie ID, unless it is in none.
+func DiscoveredVariables(info lifx.Info) map[string]string {
+	variables := map[string]string{
+		"mac": strings.ReplaceAll(info.MAC.String(), ":", ""),
+	}
+	if info.Group != "" {
+		variables["group"] = homie.DeviceID(info.Group)
+	}
+	return variables
+}
+
+func topicTemplateFromTopicTemplate(path string, raw topicTemplate, problems *Problems) *TopicTemplate {
+	t := &TopicTemplate{
+		Template:     raw.Template,
+		Variables:    raw.Variables,
+		Availability: raw.Availability,
+		Effect:       raw.Effect,
+	}
+	if !strings.Contains(t.Template, "{field}") {
+		problems.add(field(path, "template"), "must contain {field}, found %q", t.Template)
+	}
+	for _, name := range []string{"label", "field"} {
+		if _, ok := t.Variables[name]; ok {
+			problems.add(key(field(path, "variables"), name), "is built in, and cannot be set")
+		}
+	}
+	return t
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
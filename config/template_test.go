@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package config
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"go.eth.moe/catbus-lifx/lifx"
+)
+
+func TestTopicTemplateTopics(t *testing.T) {
+	tests := []struct {
+		template  TopicTemplate
+		label     string
+		variables map[string]string
+		want      Topics
+		wantErr   bool
+	}{
+		{
+			template: TopicTemplate{Template: "lights/{name}/{field}"},
+			label:    "Bedside Lamp",
+			want: Topics{
+				Power:      "lights/bedside-lamp/power",
+				Hue:        "lights/bedside-lamp/hue",
+				Saturation: "lights/bedside-lamp/saturation",
+				Brightness: "lights/bedside-lamp/brightness",
+				Kelvin:     "lights/bedside-lamp/kelvin",
+			},
+		},
+		{
+			template: TopicTemplate{Template: "{label}/{field}", Availability: true, Effect: true},
+			label:    "Bedside Lamp",
+			want: Topics{
+				Power:        "Bedside Lamp/power",
+				Hue:          "Bedside Lamp/hue",
+				Saturation:   "Bedside Lamp/saturation",
+				Brightness:   "Bedside Lamp/brightness",
+				Kelvin:       "Bedside Lamp/kelvin",
+				Availability: "Bedside Lamp/availability",
+				Effect:       "Bedside Lamp/effect",
+			},
+		},
+		{
+			// A bulb's variables override the template's, including the built in "name".
+			template:  TopicTemplate{Template: "home/{room}/{name}/{field}", Variables: map[string]string{"room": "hall"}},
+			label:     "Bedside Lamp",
+			variables: map[string]string{"room": "bedroom", "name": "lamp"},
+			want: Topics{
+				Power:      "home/bedroom/lamp/power",
+				Hue:        "home/bedroom/lamp/hue",
+				Saturation: "home/bedroom/lamp/saturation",
+				Brightness: "home/bedroom/lamp/brightness",
+				Kelvin:     "home/bedroom/lamp/kelvin",
+			},
+		},
+		{
+			// Discovered bulbs have "mac" and "group".
+			template:  TopicTemplate{Template: "lifx/{group}/{mac}/{field}"},
+			label:     "Bedside Lamp",
+			variables: map[string]string{"mac": "d073d5010203", "group": "bedroom"},
+			want: Topics{
+				Power:      "lifx/bedroom/d073d5010203/power",
+				Hue:        "lifx/bedroom/d073d5010203/hue",
+				Saturation: "lifx/bedroom/d073d5010203/saturation",
+				Brightness: "lifx/bedroom/d073d5010203/brightness",
+				Kelvin:     "lifx/bedroom/d073d5010203/kelvin",
+			},
+		},
+		{
+			// Unknown variables are an error, and expand as empty.
+			template: TopicTemplate{Template: "home/{room}/{name}/{field}"},
+			label:    "Bedside Lamp",
+			want: Topics{
+				Power:      "home//bedside-lamp/power",
+				Hue:        "home//bedside-lamp/hue",
+				Saturation: "home//bedside-lamp/saturation",
+				Brightness: "home//bedside-lamp/brightness",
+				Kelvin:     "home//bedside-lamp/kelvin",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.template.Topics(tt.label, tt.variables)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q.Topics(%q, %v) error = %v, want error %v", tt.template.Template, tt.label, tt.variables, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("%q.Topics(%q, %v) = %+v, want %+v", tt.template.Template, tt.label, tt.variables, got, tt.want)
+		}
+	}
+}
+
+func TestDiscoveredVariables(t *testing.T) {
+	mac, err := net.ParseMAC("d0:73:d5:01:02:03")
+	if err != nil {
+		t.Fatalf("ParseMAC: %v", err)
+	}
+
+	tests := []struct {
+		info lifx.Info
+		want map[string]string
+	}{
+		{
+			info: lifx.Info{MAC: mac, Group: "Master Bedroom"},
+			want: map[string]string{"mac": "d073d5010203", "group": "master-bedroom"},
+		},
+		{
+			// Bulbs in no group have no "group", so templates that use it report it as missing.
+			info: lifx.Info{MAC: mac},
+			want: map[string]string{"mac": "d073d5010203"},
+		},
+	}
+	for _, tt := range tests {
+		if got := DiscoveredVariables(tt.info); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("DiscoveredVariables(%+v) = %v, want %v", tt.info, got, tt.want)
+		}
+	}
+}
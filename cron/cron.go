@@ -0,0 +1,345 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+// Package cron runs jobs at times given by cron-like specs, optionally relative to sunrise or sunset.
+//
+// A spec is either the 5 standard cron fields, "minute hour day-of-month month day-of-week", e.g. "30 7 * * 1-5",
+// or a solar event with an optional offset in place of the minute and hour, e.g. "sunset-30m * * 1-5" or just "sunrise".
+package cron
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.eth.moe/catbus-lifx/solar"
+)
+
+type (
+	// Spec is when a job runs.
+	Spec interface {
+		// Next returns the first time the job runs after a given time, in that time's location, or the zero time if it never does.
+		Next(after time.Time) time.Time
+	}
+
+	// Clock tells the time, and can be replaced, e.g. in tests.
+	Clock interface {
+		Now() time.Time
+		After(time.Duration) <-chan time.Time
+	}
+
+	// Job is something to run at the times of a Spec.
+	Job struct {
+		Spec Spec
+		// CatchUp is how long after a missed time, e.g. while the process was not running, the job is still run when the Scheduler starts.
+		CatchUp time.Duration
+		// Run is called with the time the job was due.
+		Run func(due time.Time)
+	}
+
+	// Scheduler runs Jobs.
+	Scheduler struct {
+		Clock Clock
+		Jobs  []Job
+	}
+
+	systemClock struct{}
+
+	// fields are the days a spec runs on, as sets of allowed values.
+	fields struct {
+		daysOfMonth set
+		months      set
+		daysOfWeek  set
+		// If only one of days of month and days of week is restricted, only it is checked; if both are, either can match, as with cron.
+		restrictedDaysOfMonth bool
+		restrictedDaysOfWeek  bool
+	}
+
+	cronSpec struct {
+		minutes set
+		hours   set
+		fields
+	}
+
+	solarSpec struct {
+		sunset              bool
+		offset              time.Duration
+		latitude, longitude float64
+		fields
+	}
+
+	set uint64
+)
+
+// SystemClock is the real clock.
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// maxDays is how far ahead to look for a spec's next time, long enough to find e.g. the 29th of February.
+const maxDays = 8 * 366
+
+// IsSolar returns whether a spec is relative to sunrise or sunset, and so needs ParseSolar.
+func IsSolar(raw string) bool {
+	return strings.HasPrefix(raw, "sunrise") || strings.HasPrefix(raw, "sunset")
+}
+
+// Parse parses a standard 5-field cron spec.
+func Parse(raw string) (Spec, error) {
+	parts := strings.Fields(raw)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("cron spec must have 5 fields, found %q", raw)
+	}
+
+	minutes, err := parseSet(parts[0], 0, 59)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minute: %w", err)
+	}
+	hours, err := parseSet(parts[1], 0, 23)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hour: %w", err)
+	}
+	f, err := parseFields(parts[2:])
+	if err != nil {
+		return nil, err
+	}
+	return &cronSpec{minutes: minutes, hours: hours, fields: f}, nil
+}
+
+// ParseSolar parses a spec relative to sunrise or sunset, e.g. "sunset-30m * * 1-5", at a latitude and longitude.
+func ParseSolar(raw string, latitude, longitude float64) (Spec, error) {
+	parts := strings.Fields(raw)
+	if len(parts) != 1 && len(parts) != 4 {
+		return nil, fmt.Errorf("solar spec must be an event with an optional offset, and optionally 3 more fields, found %q", raw)
+	}
+
+	s := &solarSpec{latitude: latitude, longitude: longitude}
+	event := parts[0]
+	switch {
+	case strings.HasPrefix(event, "sunrise"):
+		event = strings.TrimPrefix(event, "sunrise")
+	case strings.HasPrefix(event, "sunset"):
+		event = strings.TrimPrefix(event, "sunset")
+		s.sunset = true
+	default:
+		return nil, fmt.Errorf("solar event must be sunrise or sunset, found %q", parts[0])
+	}
+	if event != "" {
+		if !strings.HasPrefix(event, "+") && !strings.HasPrefix(event, "-") {
+			return nil, fmt.Errorf("solar offset must start with + or -, found %q", event)
+		}
+		offset, err := time.ParseDuration(event)
+		if err != nil {
+			return nil, fmt.Errorf("invalid solar offset: %w", err)
+		}
+		s.offset = offset
+	}
+
+	days := []string{"*", "*", "*"}
+	if len(parts) == 4 {
+		days = parts[1:]
+	}
+	f, err := parseFields(days)
+	if err != nil {
+		return nil, err
+	}
+	s.fields = f
+	return s, nil
+}
+
+func parseFields(parts []string) (fields, error) {
+	daysOfMonth, err := parseSet(parts[0], 1, 31)
+	if err != nil {
+		return fields{}, fmt.Errorf("invalid day of month: %w", err)
+	}
+	months, err := parseSet(parts[1], 1, 12)
+	if err != nil {
+		return fields{}, fmt.Errorf("invalid month: %w", err)
+	}
+	daysOfWeek, err := parseSet(parts[2], 0, 7)
+	if err != nil {
+		return fields{}, fmt.Errorf("invalid day of week: %w", err)
+	}
+	// Both 0 and 7 are Sunday.
+	if daysOfWeek.has(7) {
+		daysOfWeek |= 1
+	}
+	return fields{
+		daysOfMonth:           daysOfMonth,
+		months:                months,
+		daysOfWeek:            daysOfWeek,
+		restrictedDaysOfMonth: parts[0] != "*",
+		restrictedDaysOfWeek:  parts[2] != "*",
+	}, nil
+}
+
+// parseSet parses a cron field, e.g. "*", "5", "1-5", "*/15", "5/15", or "1,3,5-7".
+// As with cron, a step after a single number runs from it to the maximum, so "5/15" of minutes is 5, 20, 35, and 50.
+func parseSet(raw string, min, max int) (set, error) {
+	var s set
+	for _, part := range strings.Split(raw, ",") {
+		step, stepped := 1, false
+		if i := strings.Index(part, "/"); i != -1 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("step must be a positive number, found %q", part[i+1:])
+			}
+			step, stepped = n, true
+			part = part[:i]
+		}
+
+		from, to := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("range must be numbers, found %q", part)
+			}
+			if to, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("range must be numbers, found %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("must be *, a number, or a range, found %q", part)
+			}
+			from, to = n, n
+			if stepped {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("must be within [%d,%d], found %q", min, max, part)
+		}
+
+		for v := from; v <= to; v += step {
+			s |= 1 << uint(v)
+		}
+	}
+	return s, nil
+}
+
+func (s set) has(v int) bool {
+	return s&(1<<uint(v)) != 0
+}
+
+// matches returns whether a spec runs on the day of t.
+func (f fields) matches(t time.Time) bool {
+	if !f.months.has(int(t.Month())) {
+		return false
+	}
+	dayOfMonth := f.daysOfMonth.has(t.Day())
+	dayOfWeek := f.daysOfWeek.has(int(t.Weekday()))
+	switch {
+	case f.restrictedDaysOfMonth && f.restrictedDaysOfWeek:
+		return dayOfMonth || dayOfWeek
+	case f.restrictedDaysOfMonth:
+		return dayOfMonth
+	case f.restrictedDaysOfWeek:
+		return dayOfWeek
+	default:
+		return true
+	}
+}
+
+func (s *cronSpec) Next(after time.Time) time.Time {
+	year, month, day := after.Date()
+	for i := 0; i < maxDays; i++ {
+		date := time.Date(year, month, day+i, 0, 0, 0, 0, after.Location())
+		if !s.matches(date) {
+			continue
+		}
+		for hour := 0; hour < 24; hour++ {
+			if !s.hours.has(hour) {
+				continue
+			}
+			for minute := 0; minute < 60; minute++ {
+				if !s.minutes.has(minute) {
+					continue
+				}
+				t := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, after.Location())
+				if t.After(after) {
+					return t
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func (s *solarSpec) Next(after time.Time) time.Time {
+	year, month, day := after.Date()
+	// Start from the day before, in case a negative offset moves a time onto the day after.
+	for i := -1; i < maxDays; i++ {
+		date := time.Date(year, month, day+i, 12, 0, 0, 0, after.Location())
+		if !s.matches(date) {
+			continue
+		}
+		sunrise, sunset, ok := solar.Times(date, s.latitude, s.longitude)
+		if !ok {
+			continue
+		}
+		t := sunrise
+		if s.sunset {
+			t = sunset
+		}
+		t = t.Add(s.offset).Truncate(time.Second)
+		if t.After(after) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Run runs jobs when they are due, until ctx is done.
+// When it starts, it first runs the most recent missed time of each job within the job's CatchUp.
+func (s *Scheduler) Run(ctx context.Context) {
+	now := s.Clock.Now()
+	nexts := make([]time.Time, len(s.Jobs))
+	for i, job := range s.Jobs {
+		if job.CatchUp > 0 {
+			var missed time.Time
+			for t := job.Spec.Next(now.Add(-job.CatchUp)); !t.IsZero() && !t.After(now); t = job.Spec.Next(t) {
+				missed = t
+			}
+			if !missed.IsZero() {
+				job.Run(missed)
+			}
+		}
+		nexts[i] = job.Spec.Next(now)
+	}
+
+	for {
+		var earliest time.Time
+		for _, next := range nexts {
+			if !next.IsZero() && (earliest.IsZero() || next.Before(earliest)) {
+				earliest = next
+			}
+		}
+		if earliest.IsZero() {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.Clock.After(earliest.Sub(s.Clock.Now())):
+		}
+
+		// If the clock jumped, e.g. after a suspend, each job still only runs once.
+		now := s.Clock.Now()
+		for i, job := range s.Jobs {
+			if nexts[i].IsZero() || nexts[i].After(now) {
+				continue
+			}
+			job.Run(nexts[i])
+			nexts[i] = job.Spec.Next(now)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package cron
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseNext(t *testing.T) {
+	// Monday the 6th of January 2020.
+	monday := time.Date(2020, time.January, 6, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec  string
+		after time.Time
+		want  []time.Time
+	}{
+		{
+			spec:  "30 7 * * *",
+			after: monday,
+			want: []time.Time{
+				time.Date(2020, time.January, 6, 7, 30, 0, 0, time.UTC),
+				time.Date(2020, time.January, 7, 7, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			spec:  "*/15 8 * * *",
+			after: monday,
+			want: []time.Time{
+				time.Date(2020, time.January, 6, 8, 0, 0, 0, time.UTC),
+				time.Date(2020, time.January, 6, 8, 15, 0, 0, time.UTC),
+				time.Date(2020, time.January, 6, 8, 30, 0, 0, time.UTC),
+				time.Date(2020, time.January, 6, 8, 45, 0, 0, time.UTC),
+				time.Date(2020, time.January, 7, 8, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			spec:  "5/15 8 * * *",
+			after: monday,
+			want: []time.Time{
+				time.Date(2020, time.January, 6, 8, 5, 0, 0, time.UTC),
+				time.Date(2020, time.January, 6, 8, 20, 0, 0, time.UTC),
+				time.Date(2020, time.January, 6, 8, 35, 0, 0, time.UTC),
+				time.Date(2020, time.January, 6, 8, 50, 0, 0, time.UTC),
+				time.Date(2020, time.January, 7, 8, 5, 0, 0, time.UTC),
+			},
+		},
+		{
+			spec:  "0 9-17/4 * * *",
+			after: monday,
+			want: []time.Time{
+				time.Date(2020, time.January, 6, 9, 0, 0, 0, time.UTC),
+				time.Date(2020, time.January, 6, 13, 0, 0, 0, time.UTC),
+				time.Date(2020, time.January, 6, 17, 0, 0, 0, time.UTC),
+				time.Date(2020, time.January, 7, 9, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			spec:  "0 12 * * 1-5",
+			after: time.Date(2020, time.January, 10, 13, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2020, time.January, 13, 12, 0, 0, 0, time.UTC),
+				time.Date(2020, time.January, 14, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			// Both 0 and 7 are Sunday.
+			spec:  "0 12 * * 7",
+			after: monday,
+			want: []time.Time{
+				time.Date(2020, time.January, 12, 12, 0, 0, 0, time.UTC),
+				time.Date(2020, time.January, 19, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			// With both days of month and days of week, either matches, as with cron.
+			spec:  "0 12 15 * 3",
+			after: monday,
+			want: []time.Time{
+				time.Date(2020, time.January, 8, 12, 0, 0, 0, time.UTC),
+				time.Date(2020, time.January, 15, 12, 0, 0, 0, time.UTC),
+				time.Date(2020, time.January, 22, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			spec:  "0 0 29 2 *",
+			after: monday,
+			want: []time.Time{
+				time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			spec:  "0,30 6 1,15 1-3 *",
+			after: time.Date(2020, time.January, 15, 6, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2020, time.January, 15, 6, 30, 0, 0, time.UTC),
+				time.Date(2020, time.February, 1, 6, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		spec, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		after := tt.after
+		for _, want := range tt.want {
+			got := spec.Next(after)
+			if !got.Equal(want) {
+				t.Errorf("Parse(%q).Next(%v) = %v, want %v", tt.spec, after, got, want)
+				break
+			}
+			after = got
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+	} {
+		if _, err := Parse(raw); err == nil {
+			t.Errorf("Parse(%q) = nil error, want an error", raw)
+		}
+	}
+}
+
+// fakeClock is stopped at a time, and sends the duration of each wait on waits, and then waits for the time to fire.
+type fakeClock struct {
+	now   time.Time
+	waits chan time.Duration
+	fire  chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits <- d
+	return c.fire
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	spec, err := Parse("0 7 * * *")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	now := time.Date(2020, time.January, 6, 8, 30, 0, 0, time.UTC)
+	due := time.Date(2020, time.January, 6, 7, 0, 0, 0, time.UTC)
+	next := time.Date(2020, time.January, 7, 7, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		catchUp time.Duration
+		want    []time.Time
+	}{
+		{catchUp: 0, want: nil},
+		{catchUp: time.Hour, want: nil},
+		{catchUp: 2 * time.Hour, want: []time.Time{due}},
+		// Only the most recent missed time is run.
+		{catchUp: 72 * time.Hour, want: []time.Time{due}},
+	}
+	for _, tt := range tests {
+		clock := &fakeClock{now: now, waits: make(chan time.Duration), fire: make(chan time.Time)}
+		var ran []time.Time
+		s := &Scheduler{
+			Clock: clock,
+			Jobs: []Job{{
+				Spec:    spec,
+				CatchUp: tt.catchUp,
+				Run:     func(due time.Time) { ran = append(ran, due) },
+			}},
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			s.Run(ctx)
+			close(done)
+		}()
+
+		if wait := <-clock.waits; wait != next.Sub(now) {
+			t.Errorf("with CatchUp %v, waited %v, want %v", tt.catchUp, wait, next.Sub(now))
+		}
+		if !equalTimes(ran, tt.want) {
+			t.Errorf("with CatchUp %v, caught up %v, want %v", tt.catchUp, ran, tt.want)
+		}
+
+		// Once the next time comes, it is run, and the scheduler waits for the one after.
+		ran = nil
+		clock.now = next
+		clock.fire <- next
+		<-clock.waits
+		if !equalTimes(ran, []time.Time{next}) {
+			t.Errorf("with CatchUp %v, ran %v, want %v", tt.catchUp, ran, []time.Time{next})
+		}
+
+		cancel()
+		<-done
+	}
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,212 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+// Package effects runs software effects on Lifx bulbs, for effects that the bulbs' own waveforms cannot do.
+package effects
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.eth.moe/catbus-lifx/lifx"
+)
+
+// FrameInterval is how often each bulb's color is changed.
+// Lifx recommends sending each bulb no more than 20 messages per second.
+const FrameInterval = 100 * time.Millisecond
+
+// None is the name for no effect, used to stop effects.
+const None = "none"
+
+type (
+	// Effect changes the color of a bulb over time.
+	Effect struct {
+		// Color returns the color at a position through the effect, where each whole number is one period, given the bulb's color before the effect started.
+		Color func(base lifx.HSBK, position float64, r *rand.Rand) lifx.HSBK
+
+		// Period is how long one cycle of the effect takes, unless otherwise set.
+		Period time.Duration
+
+		// Smooth is whether to fade between frames, rather than change instantly.
+		Smooth bool
+	}
+)
+
+// Effects are the effects by name.
+var Effects = map[string]Effect{
+	"breathe": {Color: breathe, Period: 4 * time.Second, Smooth: true},
+	"candle":  {Color: candle, Period: time.Second, Smooth: true},
+	"cycle":   {Color: cycle, Period: 30 * time.Second, Smooth: true},
+	"drift":   {Color: drift, Period: time.Minute, Smooth: true},
+	"strobe":  {Color: strobe, Period: 2 * FrameInterval},
+}
+
+// Names returns the names of all effects, sorted.
+func Names() []string {
+	var names []string
+	for name := range Effects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse parses an effect name, optionally followed by a period, e.g. "cycle" or "cycle 10s".
+func Parse(raw string) (Effect, time.Duration, error) {
+	parts := strings.Fields(raw)
+	if len(parts) == 0 || len(parts) > 2 {
+		return Effect{}, 0, fmt.Errorf("effect must be a name and an optional period, found %q", raw)
+	}
+
+	effect, ok := Effects[parts[0]]
+	if !ok {
+		return Effect{}, 0, fmt.Errorf("effect must be one of %v, found %q", strings.Join(Names(), ", "), parts[0])
+	}
+
+	period := effect.Period
+	if len(parts) == 2 {
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return Effect{}, 0, fmt.Errorf("invalid effect period: %w", err)
+		}
+		if d < 2*FrameInterval {
+			return Effect{}, 0, fmt.Errorf("effect period must be at least %v, found %v", 2*FrameInterval, d)
+		}
+		period = d
+	}
+	return effect, period, nil
+}
+
+// Start starts an effect on several bulbs, offsetting each bulb's phase evenly across the period, and returns a function to stop it.
+// The context is only used to read the bulbs' colors before the effect starts.
+// Stopping waits for the effect to stop changing the bulbs, and if restore is set, returns them to their colors from before the effect.
+func Start(ctx context.Context, effect Effect, period time.Duration, bulbs ...lifx.Bulb) (stop func(restore bool), err error) {
+	bases := make([]lifx.HSBK, len(bulbs))
+	for i, bulb := range bulbs {
+		state, err := bulb.State(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not read bulb state: %w", err)
+		}
+		bases[i] = state.Color
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, bulb := range bulbs {
+		i, bulb := i, bulb
+		phase := float64(i) / float64(len(bulbs))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(ctx, effect, period, phase, start, bases[i], bulb)
+		}()
+	}
+
+	var once sync.Once
+	return func(restore bool) {
+		once.Do(func() {
+			cancel()
+			wg.Wait()
+			if !restore {
+				return
+			}
+			for i, bulb := range bulbs {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				_ = bulb.SetColor(ctx, bases[i], FrameInterval)
+				cancel()
+			}
+		})
+	}, nil
+}
+
+func run(ctx context.Context, effect Effect, period time.Duration, phase float64, start time.Time, base lifx.HSBK, bulb lifx.Bulb) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	transition := time.Duration(0)
+	if effect.Smooth {
+		transition = FrameInterval
+	}
+
+	ticker := time.NewTicker(FrameInterval)
+	defer ticker.Stop()
+	for {
+		position := float64(time.Since(start))/float64(period) + phase
+		color := effect.Color(base, position, r)
+
+		// Frames that are dropped are replaced by the next one, so errors are ignored.
+		frameCtx, cancel := context.WithTimeout(ctx, FrameInterval)
+		_ = bulb.SetColor(frameCtx, color, transition)
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// breathe fades the brightness down to a tenth and back up.
+func breathe(base lifx.HSBK, position float64, _ *rand.Rand) lifx.HSBK {
+	depth := 0.9 * (1 - math.Cos(2*math.Pi*position)) / 2
+	base.Brightness = int(math.Round(float64(base.Brightness) * (1 - depth)))
+	return base
+}
+
+// candle flickers the brightness and warmth randomly, like a flame.
+func candle(base lifx.HSBK, _ float64, r *rand.Rand) lifx.HSBK {
+	base.Brightness = int(math.Round(float64(base.Brightness) * (0.7 + 0.3*r.Float64())))
+	base.Kelvin = lifx.MinKelvin + r.Intn(300)
+	base.Saturation = 0
+	return base
+}
+
+// cycle rotates the hue all the way around each period, e.g. a rainbow across several bulbs.
+func cycle(base lifx.HSBK, position float64, _ *rand.Rand) lifx.HSBK {
+	base.Hue = wrapHue(float64(base.Hue) + 360*position)
+	base.Saturation = colorful(base.Saturation)
+	return base
+}
+
+// drift wanders the hue back and forth either side of the original color, without repeating each period.
+func drift(base lifx.HSBK, position float64, _ *rand.Rand) lifx.HSBK {
+	offset := 90*math.Sin(2*math.Pi*position) + 45*math.Sin(2*math.Pi*position*math.Phi) + 20*math.Sin(2*math.Pi*position*math.E)
+	base.Hue = wrapHue(float64(base.Hue) + offset)
+	base.Saturation = colorful(base.Saturation)
+	return base
+}
+
+// strobe flashes between full brightness and off, once per period.
+func strobe(base lifx.HSBK, position float64, _ *rand.Rand) lifx.HSBK {
+	if position-math.Floor(position) < 0.5 {
+		base.Brightness = lifx.MaxBrightness
+	} else {
+		base.Brightness = lifx.MinBrightness
+	}
+	return base
+}
+
+func wrapHue(hue float64) int {
+	h := int(math.Round(hue)) % (lifx.MaxHue + 1)
+	if h < 0 {
+		h += lifx.MaxHue + 1
+	}
+	return h
+}
+
+// colorful returns a saturation that makes hue changes visible, for bulbs that are white.
+func colorful(saturation int) int {
+	if saturation == 0 {
+		return lifx.MaxSaturation
+	}
+	return saturation
+}
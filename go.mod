@@ -4,14 +4,16 @@
 
 module go.eth.moe/catbus-lifx
 
-go 1.13
+go 1.16
 
 require (
-	github.com/eclipse/paho.mqtt.golang v1.3.0 // indirect
+	github.com/BurntSushi/toml v1.3.2
+	github.com/eclipse/paho.mqtt.golang v1.3.0
 	github.com/sirupsen/logrus v1.7.0 // indirect
 	go.eth.moe/catbus v0.0.6
 	go.eth.moe/flag v0.0.2
 	go.eth.moe/logger v0.0.1
 	golang.org/x/net v0.0.0-20201209123823-ac852fbbde11 // indirect
 	golang.org/x/sys v0.0.0-20201211090839-8ad439b19e0f // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+// Package homeassistant describes Lifx bulbs for Home Assistant's MQTT discovery, using its JSON light schema.
+//
+// See https://www.home-assistant.io/integrations/light.mqtt/#json-schema.
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.eth.moe/catbus-lifx/lifx"
+)
+
+type (
+	// Discovery is a discovery document, published to ConfigTopic.
+	Discovery struct {
+		Name     string `json:"name"`
+		UniqueID string `json:"unique_id"`
+		Schema   string `json:"schema"`
+
+		StateTopic   string `json:"state_topic"`
+		CommandTopic string `json:"command_topic"`
+
+		Availability     []Availability `json:"availability,omitempty"`
+		AvailabilityMode string         `json:"availability_mode,omitempty"`
+
+		Brightness          bool     `json:"brightness"`
+		BrightnessScale     int      `json:"brightness_scale"`
+		SupportedColorModes []string `json:"supported_color_modes"`
+		ColorTempKelvin     bool     `json:"color_temp_kelvin"`
+		MinKelvin           int      `json:"min_kelvin"`
+		MaxKelvin           int      `json:"max_kelvin"`
+
+		Device Device `json:"device"`
+	}
+
+	// Availability is a topic that says whether the light is available.
+	Availability struct {
+		Topic               string `json:"topic"`
+		PayloadAvailable    string `json:"payload_available"`
+		PayloadNotAvailable string `json:"payload_not_available"`
+	}
+
+	// Device is the physical bulb behind a Discovery.
+	Device struct {
+		Identifiers  []string    `json:"identifiers"`
+		Connections  [][2]string `json:"connections"`
+		Name         string      `json:"name"`
+		Manufacturer string      `json:"manufacturer"`
+		Model        string      `json:"model"`
+		SWVersion    string      `json:"sw_version"`
+	}
+
+	// State is both the state published to StateTopic and the commands received on CommandTopic.
+	// Commands may omit any field, and only the fields present are changed.
+	State struct {
+		State      string `json:"state,omitempty"`
+		ColorMode  string `json:"color_mode,omitempty"`
+		Brightness *int   `json:"brightness,omitempty"`
+		Color      *Color `json:"color,omitempty"`
+		ColorTemp  *int   `json:"color_temp,omitempty"`
+
+		// Transition is in seconds, and is only used by commands.
+		Transition *float64 `json:"transition,omitempty"`
+	}
+
+	// Color is a hue & saturation color.
+	Color struct {
+		Hue        float64 `json:"h"`
+		Saturation float64 `json:"s"`
+	}
+)
+
+const (
+	on  = "ON"
+	off = "OFF"
+
+	colorModeHS        = "hs"
+	colorModeColorTemp = "color_temp"
+)
+
+// ID returns the Home Assistant object ID for a bulb.
+func ID(info lifx.Info) string {
+	return "lifx_" + strings.ReplaceAll(info.MAC.String(), ":", "")
+}
+
+// ConfigTopic returns the topic to publish a bulb's Discovery to.
+func ConfigTopic(discoveryPrefix string, info lifx.Info) string {
+	return fmt.Sprintf("%v/light/%v/config", discoveryPrefix, ID(info))
+}
+
+// StateTopic returns the topic to publish a bulb's State to.
+func StateTopic(topicPrefix string, info lifx.Info) string {
+	return fmt.Sprintf("%v/%v/state", topicPrefix, ID(info))
+}
+
+// CommandTopic returns the topic to receive a bulb's commands on.
+func CommandTopic(topicPrefix string, info lifx.Info) string {
+	return fmt.Sprintf("%v/%v/set", topicPrefix, ID(info))
+}
+
+// IDFromCommandTopic returns the ID from a topic created by CommandTopic.
+func IDFromCommandTopic(topicPrefix, topic string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(topic, topicPrefix+"/"), "/")
+	if len(parts) != 2 || parts[1] != "set" {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// NewDiscovery returns the Discovery for a bulb.
+// The light is only available if all of the given availability topics say so.
+func NewDiscovery(topicPrefix, label string, info lifx.Info, availability []Availability) Discovery {
+	colorModes := []string{colorModeColorTemp}
+	if info.Product.Color {
+		colorModes = append(colorModes, colorModeHS)
+	}
+
+	return Discovery{
+		Name:     label,
+		UniqueID: ID(info),
+		Schema:   "json",
+
+		StateTopic:   StateTopic(topicPrefix, info),
+		CommandTopic: CommandTopic(topicPrefix, info),
+
+		Availability:     availability,
+		AvailabilityMode: "all",
+
+		Brightness:          true,
+		BrightnessScale:     lifx.MaxBrightness,
+		SupportedColorModes: colorModes,
+		ColorTempKelvin:     true,
+		MinKelvin:           clamp(info.Product.MinKelvin, lifx.MinKelvin, lifx.MaxKelvin),
+		MaxKelvin:           clamp(info.Product.MaxKelvin, lifx.MinKelvin, lifx.MaxKelvin),
+
+		Device: Device{
+			Identifiers:  []string{ID(info)},
+			Connections:  [][2]string{{"mac", info.MAC.String()}},
+			Name:         label,
+			Manufacturer: "LIFX",
+			Model:        info.Product.Name,
+			SWVersion:    info.Firmware,
+		},
+	}
+}
+
+// NewState returns the State for a bulb.
+func NewState(state lifx.State) State {
+	s := State{
+		State:      off,
+		Brightness: &state.Color.Brightness,
+	}
+	if state.Power == lifx.On {
+		s.State = on
+	}
+
+	// Lifx bulbs render pure white when saturation is 0, and a color otherwise.
+	if state.Color.Saturation == 0 {
+		s.ColorMode = colorModeColorTemp
+		s.ColorTemp = &state.Color.Kelvin
+	} else {
+		s.ColorMode = colorModeHS
+		s.Color = &Color{
+			Hue:        float64(state.Color.Hue),
+			Saturation: float64(state.Color.Saturation),
+		}
+	}
+	return s
+}
+
+// Power returns the power a command sets, if any.
+func (s State) Power() (lifx.Power, bool, error) {
+	switch s.State {
+	case "":
+		return 0, false, nil
+	case on:
+		return lifx.On, true, nil
+	case off:
+		return lifx.Off, true, nil
+	default:
+		return 0, false, fmt.Errorf("state must be %v or %v, found %q", on, off, s.State)
+	}
+}
+
+// Marshal returns v as a JSON payload.
+func Marshal(v interface{}) string {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("could not marshal %T: %v", v, err))
+	}
+	return string(bytes)
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
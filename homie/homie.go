@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+// Package homie describes Lifx bulbs as devices following the Homie MQTT convention.
+//
+// Each bulb is a device with a single node, "light", with the properties power, hue, saturation, brightness, kelvin, transition, and effect.
+//
+// See https://homieiot.github.io/specification/spec-core-v4_0_0/.
+package homie
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.eth.moe/catbus-lifx/effects"
+	"go.eth.moe/catbus-lifx/lifx"
+)
+
+type (
+	// Message is a retained message to publish.
+	Message struct {
+		Topic   string
+		Payload string
+	}
+
+	property struct {
+		id       string
+		name     string
+		datatype string
+		format   string
+		unit     string
+	}
+)
+
+const (
+	// Version is the version of the Homie convention implemented.
+	Version = "4.0.0"
+
+	// Node is the ID of the only node of each device.
+	Node = "light"
+
+	PropertyPower      = "power"
+	PropertyHue        = "hue"
+	PropertySaturation = "saturation"
+	PropertyBrightness = "brightness"
+	PropertyKelvin     = "kelvin"
+	PropertyTransition = "transition"
+	PropertyEffect     = "effect"
+)
+
+// Device lifecycle states, for the $state attribute.
+const (
+	StateInit         = "init"
+	StateReady        = "ready"
+	StateDisconnected = "disconnected"
+	StateLost         = "lost"
+)
+
+var properties = []property{
+	{id: PropertyPower, name: "Power", datatype: "boolean"},
+	{id: PropertyHue, name: "Hue", datatype: "integer", format: fmt.Sprintf("%d:%d", lifx.MinHue, lifx.MaxHue), unit: "°"},
+	{id: PropertySaturation, name: "Saturation", datatype: "integer", format: fmt.Sprintf("%d:%d", lifx.MinSaturation, lifx.MaxSaturation), unit: "%"},
+	{id: PropertyBrightness, name: "Brightness", datatype: "integer", format: fmt.Sprintf("%d:%d", lifx.MinBrightness, lifx.MaxBrightness), unit: "%"},
+	{id: PropertyKelvin, name: "Color temperature", datatype: "integer", format: fmt.Sprintf("%d:%d", lifx.MinKelvin, lifx.MaxKelvin), unit: "K"},
+	{id: PropertyTransition, name: "Transition", datatype: "integer", unit: "ms"},
+	{id: PropertyEffect, name: "Effect", datatype: "enum", format: strings.Join(append([]string{effects.None}, effects.Names()...), ",")},
+}
+
+var invalidIDCharacters = regexp.MustCompile("[^a-z0-9]+")
+
+// DeviceID returns a valid Homie ID for a bulb label, e.g. "Bedside Lamp" becomes "bedside-lamp".
+func DeviceID(label string) string {
+	return strings.Trim(invalidIDCharacters.ReplaceAllString(strings.ToLower(label), "-"), "-")
+}
+
+// StateTopic returns the topic of a device's $state attribute.
+func StateTopic(baseTopic, deviceID string) string {
+	return fmt.Sprintf("%v/%v/$state", baseTopic, deviceID)
+}
+
+// PropertyTopic returns the topic of a property's value.
+func PropertyTopic(baseTopic, deviceID, property string) string {
+	return fmt.Sprintf("%v/%v/%v/%v", baseTopic, deviceID, Node, property)
+}
+
+// SetTopic returns the topic to receive changes to a property's value on.
+func SetTopic(baseTopic, deviceID, property string) string {
+	return PropertyTopic(baseTopic, deviceID, property) + "/set"
+}
+
+// DeviceAttributes returns the attributes describing a device, its node, and its properties.
+// They do not include $state, which should be published as StateInit before and StateReady after.
+func DeviceAttributes(baseTopic, deviceID, name string) []Message {
+	device := fmt.Sprintf("%v/%v", baseTopic, deviceID)
+	node := fmt.Sprintf("%v/%v", device, Node)
+
+	var propertyIDs []string
+	for _, p := range properties {
+		propertyIDs = append(propertyIDs, p.id)
+	}
+
+	messages := []Message{
+		{device + "/$homie", Version},
+		{device + "/$name", name},
+		{device + "/$nodes", Node},
+		// $extensions is omitted, as no extensions are supported, and an empty retained message would delete it rather than publish it.
+		{node + "/$name", name},
+		{node + "/$type", "Lifx bulb"},
+		{node + "/$properties", strings.Join(propertyIDs, ",")},
+	}
+	for _, p := range properties {
+		topic := PropertyTopic(baseTopic, deviceID, p.id)
+		messages = append(messages,
+			Message{topic + "/$name", p.name},
+			Message{topic + "/$datatype", p.datatype},
+			Message{topic + "/$settable", "true"},
+		)
+		if p.format != "" {
+			messages = append(messages, Message{topic + "/$format", p.format})
+		}
+		if p.unit != "" {
+			messages = append(messages, Message{topic + "/$unit", p.unit})
+		}
+	}
+	return messages
+}
+
+// PropertyValues returns the property values of a bulb's State.
+// The transition and effect properties are not part of the bulb's State, see TransitionValue.
+func PropertyValues(baseTopic, deviceID string, state lifx.State) []Message {
+	return []Message{
+		{PropertyTopic(baseTopic, deviceID, PropertyPower), strconv.FormatBool(state.Power == lifx.On)},
+		{PropertyTopic(baseTopic, deviceID, PropertyHue), strconv.Itoa(state.Color.Hue)},
+		{PropertyTopic(baseTopic, deviceID, PropertySaturation), strconv.Itoa(state.Color.Saturation)},
+		{PropertyTopic(baseTopic, deviceID, PropertyBrightness), strconv.Itoa(state.Color.Brightness)},
+		{PropertyTopic(baseTopic, deviceID, PropertyKelvin), strconv.Itoa(state.Color.Kelvin)},
+	}
+}
+
+// TransitionValue returns the transition property value for a duration.
+func TransitionValue(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
+
+// ParsePower parses a power property value.
+func ParsePower(raw string) (lifx.Power, error) {
+	switch raw {
+	case "true":
+		return lifx.On, nil
+	case "false":
+		return lifx.Off, nil
+	default:
+		return lifx.Off, fmt.Errorf("power must be true or false, found %q", raw)
+	}
+}
+
+// ParseTransition parses a transition property value.
+func ParseTransition(raw string) (time.Duration, error) {
+	ms, err := strconv.Atoi(raw)
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf("transition must be a non-negative number of milliseconds, found %q", raw)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
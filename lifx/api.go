@@ -10,6 +10,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"time"
 )
@@ -51,14 +52,30 @@ type (
 		Color HSBK
 	}
 
+	// Info is the static information about a given bulb.
+	Info struct {
+		// MAC is the bulb's hardware address, e.g. d0:73:d5:01:02:03.
+		MAC      net.HardwareAddr
+		Product  Product
+		Firmware string
+		// Group is the label of the group the bulb is in, in the Lifx app, if any.
+		Group string
+		// Addr is the bulb's IP address and port, and is nil for groups.
+		Addr *net.UDPAddr
+	}
+
 	// Bulb is a Lifx bulb.
 	Bulb interface {
+		// Info returns the static Info of the bulb.
+		Info(context.Context) (Info, error)
 		// State returns the current State of the bulb.
 		State(context.Context) (State, error)
 		// SetPower sets the power, with a duration to smooth the change over.
 		SetPower(context.Context, Power, time.Duration) error
 		// SetColor sets the color, with a duration to smooth the change over.
 		SetColor(context.Context, HSBK, time.Duration) error
+		// Signal returns the strength of the bulb's Wi-Fi signal, in dBm.
+		Signal(context.Context) (int, error)
 	}
 )
 
@@ -91,6 +108,12 @@ func (e *ErrInvalidColor) ok() bool {
 	return e.hue == 0 && e.saturation == 0 && e.brightness == 0 && e.kelvin == 0
 }
 
+// Validate returns an ErrInvalidColor if any part of the color is out of range.
+func (c HSBK) Validate() error {
+	_, err := uglyHSBK(c)
+	return err
+}
+
 func prettyState(s *state) State {
 	return State{
 		Label: string(bytes.Trim(s.Label[:], "\x00")),
@@ -10,6 +10,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"reflect"
 	"sync"
@@ -30,6 +31,43 @@ func (b *bulb) String() string {
 	return fmt.Sprintf("{ id: %v addr: %v }", b.id, b.addr)
 }
 
+func (b *bulb) Info(ctx context.Context) (Info, error) {
+	m, err := b.sendAndReceive(ctx, &getVersion{})
+	if err != nil {
+		return Info{}, err
+	}
+	version, ok := m.(*stateVersion)
+	if !ok {
+		return Info{}, fmt.Errorf("expected StateVersion message, got message type %v", reflect.TypeOf(m))
+	}
+
+	m, err = b.sendAndReceive(ctx, &getHostFirmware{})
+	if err != nil {
+		return Info{}, err
+	}
+	firmware, ok := m.(*stateHostFirmware)
+	if !ok {
+		return Info{}, fmt.Errorf("expected StateHostFirmware message, got message type %v", reflect.TypeOf(m))
+	}
+
+	m, err = b.sendAndReceive(ctx, &getGroup{})
+	if err != nil {
+		return Info{}, err
+	}
+	group, ok := m.(*stateGroup)
+	if !ok {
+		return Info{}, fmt.Errorf("expected StateGroup message, got message type %v", reflect.TypeOf(m))
+	}
+
+	return Info{
+		MAC:      macForID(b.id),
+		Product:  productForID(int(version.Product)),
+		Firmware: fmt.Sprintf("%d.%d", firmware.VersionMajor, firmware.VersionMinor),
+		Group:    string(bytes.Trim(group.Label[:], "\x00")),
+		Addr:     udpAddr(b.addr),
+	}, nil
+}
+
 func (b *bulb) State(ctx context.Context) (State, error) {
 	m, err := b.sendAndReceive(ctx, &get{})
 	if err != nil {
@@ -43,6 +81,22 @@ func (b *bulb) State(ctx context.Context) (State, error) {
 	return prettyState(rawState), nil
 }
 
+func (b *bulb) Signal(ctx context.Context) (int, error) {
+	m, err := b.sendAndReceive(ctx, &getWifiInfo{})
+	if err != nil {
+		return 0, err
+	}
+	wifi, ok := m.(*stateWifiInfo)
+	if !ok {
+		return 0, fmt.Errorf("expected StateWifiInfo message, got message type %v", reflect.TypeOf(m))
+	}
+	if wifi.Signal <= 0 {
+		return 0, fmt.Errorf("bulb reported a signal of %v mW", wifi.Signal)
+	}
+	// Bulbs report milliwatts, which Lifx converts to dBm, rounding half up.
+	return int(math.Floor(10*math.Log10(float64(wifi.Signal)) + 0.5)), nil
+}
+
 func (b *bulb) SetColor(ctx context.Context, hsbk HSBK, d time.Duration) error {
 	color, err := uglyHSBK(hsbk)
 	if err != nil {
@@ -53,8 +107,15 @@ func (b *bulb) SetColor(ctx context.Context, hsbk HSBK, d time.Duration) error {
 		Duration: uint32(d.Milliseconds()),
 	}
 
-	_, err = b.sendAndReceive(ctx, req)
-	return err
+	// The bulb confirms with its State from before the change, so callers should trust the color they set instead.
+	m, err := b.sendAndReceive(ctx, req)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.(*state); !ok {
+		return fmt.Errorf("expected State message, got message type %v", reflect.TypeOf(m))
+	}
+	return nil
 }
 
 func (b *bulb) SetPower(ctx context.Context, p Power, d time.Duration) error {
@@ -62,23 +123,19 @@ func (b *bulb) SetPower(ctx context.Context, p Power, d time.Duration) error {
 		Power:    uint16(p),
 		Duration: uint32(d.Milliseconds()),
 	}
-	_, err := b.sendAndReceive(ctx, req)
-	return err
-}
-func (b *bulb) sendAndReceive(ctx context.Context, message interface{}) (interface{}, error) {
-	var payload bytes.Buffer
-	_ = binary.Write(&payload, binary.LittleEndian, message)
 
-	hdr := &header{
-		Size:             uint16(headerLength + payload.Len()),
-		Tagged:           false,
-		Source:           source,
-		Target:           b.id,
-		ResponseRequired: true,
-		Sequence:         b.nextSequence(),
-		Type:             typeForMessage(message),
+	// The bulb confirms with its StatePower from before the change, so callers should trust the power they set instead.
+	m, err := b.sendAndReceive(ctx, req)
+	if err != nil {
+		return err
 	}
-	packet := append(hdr.Bytes(), payload.Bytes()...)
+	if _, ok := m.(*statePower); !ok {
+		return fmt.Errorf("expected StatePower message, got message type %v", reflect.TypeOf(m))
+	}
+	return nil
+}
+func (b *bulb) sendAndReceive(ctx context.Context, message interface{}) (interface{}, error) {
+	packet := b.packet(message, true, false)
 
 	conn, err := net.Dial(b.addr.Network(), b.addr.String())
 	if err != nil {
@@ -105,7 +162,7 @@ func (b *bulb) sendAndReceive(ctx context.Context, message interface{}) (interfa
 		return nil, fmt.Errorf("could not read packet: %w", err)
 	}
 
-	hdr = &header{}
+	hdr := &header{}
 	hdr.FromBytes(buf[0:headerLength])
 
 	message = messageForType(hdr.Type)
@@ -114,6 +171,39 @@ func (b *bulb) sendAndReceive(ctx context.Context, message interface{}) (interfa
 	return message, nil
 }
 
+// packet returns a message addressed to the bulb, asking it to respond with a reply or an Acknowledgement.
+func (b *bulb) packet(message interface{}, responseRequired, acknowledgementRequired bool) []byte {
+	var payload bytes.Buffer
+	_ = binary.Write(&payload, binary.LittleEndian, message)
+
+	hdr := &header{
+		Size:                    uint16(headerLength + payload.Len()),
+		Tagged:                  false,
+		Source:                  source,
+		Target:                  b.id,
+		ResponseRequired:        responseRequired,
+		AcknowledgementRequired: acknowledgementRequired,
+		Sequence:                b.nextSequence(),
+		Type:                    typeForMessage(message),
+	}
+	return append(hdr.Bytes(), payload.Bytes()...)
+}
+
+func udpAddr(addr net.Addr) *net.UDPAddr {
+	if a, ok := addr.(*net.UDPAddr); ok {
+		return a
+	}
+	return nil
+}
+
+// macForID returns the MAC address of a bulb from its Target ID.
+// The MAC address is the first 6 bytes of the Target, in order.
+func macForID(id uint64) net.HardwareAddr {
+	target := make([]byte, 8)
+	binary.LittleEndian.PutUint64(target, id)
+	return net.HardwareAddr(target[:6])
+}
+
 func (b *bulb) nextSequence() uint8 {
 	b.Lock()
 	defer b.Unlock()
@@ -0,0 +1,220 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package lifx
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+type (
+	group struct {
+		label   string
+		members []Bulb
+	}
+)
+
+// NewGroup returns a virtual Bulb that sends commands to all of its members at once.
+//
+// Its State is on if any member is on, with the average color of its members.
+// Members that do not respond are left out of its State, and only if none respond is it an error.
+func NewGroup(label string, members ...Bulb) Bulb {
+	return &group{
+		label:   label,
+		members: members,
+	}
+}
+
+func (g *group) String() string {
+	return fmt.Sprintf("{ group: %q members: %v }", g.label, g.members)
+}
+
+// Info returns an Info without a MAC address, whose Product is what all of the group's members are capable of.
+func (g *group) Info(ctx context.Context) (Info, error) {
+	infos := make([]Info, len(g.members))
+	if err := g.each(func(i int, b Bulb) error {
+		info, err := b.Info(ctx)
+		infos[i] = info
+		return err
+	}); err != nil {
+		return Info{}, err
+	}
+
+	product := Product{
+		Name:      "Group",
+		Color:     true,
+		MinKelvin: MinKelvin,
+		MaxKelvin: MaxKelvin,
+	}
+	for _, info := range infos {
+		product.Color = product.Color && info.Product.Color
+		if info.Product.MinKelvin > product.MinKelvin {
+			product.MinKelvin = info.Product.MinKelvin
+		}
+		if info.Product.MaxKelvin < product.MaxKelvin {
+			product.MaxKelvin = info.Product.MaxKelvin
+		}
+	}
+	return Info{Product: product}, nil
+}
+
+func (g *group) State(ctx context.Context) (State, error) {
+	states := make([]*State, len(g.members))
+	err := g.each(func(i int, b Bulb) error {
+		state, err := b.State(ctx)
+		if err == nil {
+			states[i] = &state
+		}
+		return err
+	})
+
+	var sinHue, cosHue float64
+	var saturation, brightness, kelvin, n int
+	s := State{Label: g.label, Power: Off}
+	for _, state := range states {
+		if state == nil {
+			continue
+		}
+		if state.Power == On {
+			s.Power = On
+		}
+		radians := float64(state.Color.Hue) * math.Pi / 180
+		sinHue += math.Sin(radians)
+		cosHue += math.Cos(radians)
+		saturation += state.Color.Saturation
+		brightness += state.Color.Brightness
+		kelvin += state.Color.Kelvin
+		n++
+	}
+	if n == 0 {
+		if err == nil {
+			err = ErrNoResponse
+		}
+		return State{}, err
+	}
+
+	// Hue is circular, so e.g. the average of 350° and 10° is 0°, not 180°.
+	hue := int(math.Round(math.Atan2(sinHue, cosHue) * 180 / math.Pi))
+	if hue < 0 {
+		hue += 360
+	}
+	s.Color = HSBK{
+		Hue:        hue % 360,
+		Saturation: saturation / n,
+		Brightness: brightness / n,
+		Kelvin:     kelvin / n,
+	}
+	return s, nil
+}
+
+// Signal returns the weakest signal of the group's members, and is only an error if none of them respond.
+func (g *group) Signal(ctx context.Context) (int, error) {
+	signals := make([]*int, len(g.members))
+	err := g.each(func(i int, b Bulb) error {
+		signal, err := b.Signal(ctx)
+		if err == nil {
+			signals[i] = &signal
+		}
+		return err
+	})
+
+	var weakest *int
+	for _, signal := range signals {
+		if signal != nil && (weakest == nil || *signal < *weakest) {
+			weakest = signal
+		}
+	}
+	if weakest == nil {
+		if err == nil {
+			err = ErrNoResponse
+		}
+		return 0, err
+	}
+	return *weakest, nil
+}
+
+func (g *group) SetPower(ctx context.Context, p Power, d time.Duration) error {
+	req := &setPower{
+		Power:    uint16(p),
+		Duration: uint32(d.Milliseconds()),
+	}
+	return g.together(ctx, req, func(b Bulb) error {
+		return b.SetPower(ctx, p, d)
+	})
+}
+
+func (g *group) SetColor(ctx context.Context, color HSBK, d time.Duration) error {
+	hsbk, err := uglyHSBK(color)
+	if err != nil {
+		return err
+	}
+	req := &setColor{
+		Color:    hsbk,
+		Duration: uint32(d.Milliseconds()),
+	}
+	return g.together(ctx, req, func(b Bulb) error {
+		return b.SetColor(ctx, color, d)
+	})
+}
+
+// together sends a message to all members at once, then confirms with any that did not acknowledge it by calling f for them individually.
+func (g *group) together(ctx context.Context, message interface{}, f func(Bulb) error) error {
+	var bulbs []*bulb
+	var others []Bulb
+	for _, m := range g.members {
+		if b, ok := m.(*bulb); ok {
+			bulbs = append(bulbs, b)
+		} else {
+			others = append(others, m)
+		}
+	}
+
+	missing, err := sendTogether(ctx, bulbs, message)
+	if err != nil {
+		missing = bulbs
+	}
+	for _, b := range missing {
+		others = append(others, b)
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	return (&group{label: g.label, members: others}).each(func(_ int, b Bulb) error {
+		return f(b)
+	})
+}
+
+// each calls f for every member concurrently, and returns an error wrapping the first error if any failed.
+func (g *group) each(f func(int, Bulb) error) error {
+	errs := make([]error, len(g.members))
+	var wg sync.WaitGroup
+	for i, b := range g.members {
+		i, b := i, b
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f(i, b)
+		}()
+	}
+	wg.Wait()
+
+	var first error
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	if first != nil {
+		return fmt.Errorf("%d of %d bulbs in group %q failed: %w", failed, len(g.members), g.label, first)
+	}
+	return nil
+}
@@ -42,7 +42,7 @@ type header struct {
 	// Size is the size of the entire message in bytes.
 	Size uint16
 	// Tagged specifies if Target is to address a specific bulb or all bulbs.
-	// TODO: fill in the rest of the details.
+	// A Tagged message must have a Target of all zeros, and is handled by every bulb on the network.
 	Tagged bool
 	// Source is a unique identifier for the client.
 	// If Source is 0, the bulb may send a broadcast message.
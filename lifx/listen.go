@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package lifx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+type (
+	// Update is a state message broadcast by a bulb, e.g. after a change by another client.
+	Update struct {
+		// MAC is the hardware address of the bulb that sent the Update.
+		MAC net.HardwareAddr
+
+		// State is nil if the Update only contains Power.
+		State *State
+		Power Power
+	}
+)
+
+// Listen calls f with each Update broadcast by bulbs, until the context is done.
+//
+// Bulbs broadcast their replies to clients that use a Source of 0, so this picks up changes made by other clients.
+func Listen(ctx context.Context, f func(Update)) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: broadcastAddr.Port})
+	if err != nil {
+		return fmt.Errorf("could not listen on UDP: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	for {
+		buf := make([]byte, 256)
+		n, _, err := conn.ReadFromUDP(buf)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("could not read packet: %w", err)
+		}
+		if n < headerLength {
+			continue
+		}
+
+		hdr := &header{}
+		hdr.FromBytes(buf[0:headerLength])
+
+		message := messageForType(hdr.Type)
+		reader := bytes.NewReader(buf[headerLength:n])
+
+		update := Update{MAC: macForID(hdr.Target)}
+		switch m := message.(type) {
+		case *state:
+			_ = binary.Read(reader, binary.LittleEndian, m)
+			s := prettyState(m)
+			update.State = &s
+			update.Power = s.Power
+		case *statePower:
+			_ = binary.Read(reader, binary.LittleEndian, m)
+			update.Power = Power(m.Level)
+		case *stateDevicePower:
+			_ = binary.Read(reader, binary.LittleEndian, m)
+			update.Power = Power(m.Level)
+		default:
+			continue
+		}
+		f(update)
+	}
+}
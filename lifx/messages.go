@@ -34,6 +34,42 @@ type hsbk struct {
 
 type getService struct{}
 
+type getHostFirmware struct{}
+
+type stateHostFirmware struct {
+	// Build is the firmware build time, in nanoseconds since the epoch.
+	Build    uint64
+	Reserved uint64
+	// VersionMinor and VersionMajor make up the firmware version, e.g. 3.70.
+	VersionMinor uint16
+	VersionMajor uint16
+}
+
+type getWifiInfo struct{}
+
+type stateWifiInfo struct {
+	// Signal is the received signal strength, in milliwatts.
+	Signal    float32
+	Reserved1 uint32
+	Reserved2 uint32
+	Reserved3 int16
+}
+
+type stateDevicePower struct {
+	// Level must be either 0x0000 (off) or 0xFFFF (on).
+	Level uint16
+}
+
+type getVersion struct{}
+
+type stateVersion struct {
+	// Vendor is always 1, for Lifx.
+	Vendor uint32
+	// Product identifies the model of the bulb.
+	Product  uint32
+	Reserved uint32
+}
+
 type stateService struct {
 	// Service is always 1, for "UDP".
 	Service uint8
@@ -41,6 +77,17 @@ type stateService struct {
 	Port    uint32
 }
 
+type getGroup struct{}
+
+type stateGroup struct {
+	// Group identifies the group.
+	Group [16]byte
+	// Label is the group's human-readable label.
+	Label [32]byte
+	// UpdatedAt is when the group was last changed, in nanoseconds since the epoch.
+	UpdatedAt uint64
+}
+
 type acknowledgement struct{}
 
 type get struct{}
@@ -80,8 +127,26 @@ func typeForMessage(message interface{}) uint16 {
 		return 2
 	case *stateService:
 		return 3
+	case *getHostFirmware:
+		return 14
+	case *stateHostFirmware:
+		return 15
+	case *getWifiInfo:
+		return 16
+	case *stateWifiInfo:
+		return 17
+	case *stateDevicePower:
+		return 22
+	case *getVersion:
+		return 32
+	case *stateVersion:
+		return 33
 	case *acknowledgement:
 		return 45
+	case *getGroup:
+		return 51
+	case *stateGroup:
+		return 53
 	case *get:
 		return 101
 	case *setColor:
@@ -103,8 +168,26 @@ func messageForType(typ uint16) interface{} {
 		return &getService{}
 	case 3:
 		return &stateService{}
+	case 14:
+		return &getHostFirmware{}
+	case 15:
+		return &stateHostFirmware{}
+	case 16:
+		return &getWifiInfo{}
+	case 17:
+		return &stateWifiInfo{}
+	case 22:
+		return &stateDevicePower{}
+	case 32:
+		return &getVersion{}
+	case 33:
+		return &stateVersion{}
 	case 45:
 		return &acknowledgement{}
+	case 51:
+		return &getGroup{}
+	case 53:
+		return &stateGroup{}
 	case 101:
 		return &get{}
 	case 102:
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package lifx
+
+import "fmt"
+
+// Product is a model of Lifx bulb, and what it is capable of.
+type Product struct {
+	ID   int
+	Name string
+
+	// Color is whether the bulb can change hue & saturation, or only kelvin.
+	Color bool
+
+	// MinKelvin and MaxKelvin are the range of color temperatures the bulb supports.
+	MinKelvin int
+	MaxKelvin int
+}
+
+// productsByID is a subset of the Lifx product list.
+//
+// From https://github.com/LIFX/products/blob/master/products.json.
+var productsByID = map[int]Product{
+	1:  {Name: "LIFX Original 1000", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	3:  {Name: "LIFX Color 650", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	10: {Name: "LIFX White 800 (Low Voltage)", MinKelvin: 2700, MaxKelvin: 6500},
+	11: {Name: "LIFX White 800 (High Voltage)", MinKelvin: 2700, MaxKelvin: 6500},
+	15: {Name: "LIFX Color 1000", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	18: {Name: "LIFX White 900 BR30 (Low Voltage)", MinKelvin: 2500, MaxKelvin: 9000},
+	19: {Name: "LIFX White 900 BR30 (High Voltage)", MinKelvin: 2500, MaxKelvin: 9000},
+	20: {Name: "LIFX Color 1000 BR30", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	22: {Name: "LIFX Color 1000", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	27: {Name: "LIFX A19", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	28: {Name: "LIFX BR30", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	29: {Name: "LIFX A19 Night Vision", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	30: {Name: "LIFX BR30 Night Vision", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	31: {Name: "LIFX Z", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	32: {Name: "LIFX Z", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	36: {Name: "LIFX Downlight", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	37: {Name: "LIFX Downlight", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	38: {Name: "LIFX Beam", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	43: {Name: "LIFX A19", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	44: {Name: "LIFX BR30", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	45: {Name: "LIFX A19 Night Vision", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	46: {Name: "LIFX BR30 Night Vision", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	49: {Name: "LIFX Mini Color", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	50: {Name: "LIFX Mini White to Warm", MinKelvin: 1500, MaxKelvin: 4000},
+	51: {Name: "LIFX Mini White", MinKelvin: 2700, MaxKelvin: 2700},
+	52: {Name: "LIFX GU10", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	55: {Name: "LIFX Tile", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	57: {Name: "LIFX Candle", Color: true, MinKelvin: 1500, MaxKelvin: 9000},
+	59: {Name: "LIFX Mini Color", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	60: {Name: "LIFX Mini White to Warm", MinKelvin: 1500, MaxKelvin: 4000},
+	61: {Name: "LIFX Mini White", MinKelvin: 2700, MaxKelvin: 2700},
+	62: {Name: "LIFX A19", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	63: {Name: "LIFX BR30", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	64: {Name: "LIFX A19 Night Vision", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	65: {Name: "LIFX BR30 Night Vision", Color: true, MinKelvin: 2500, MaxKelvin: 9000},
+	66: {Name: "LIFX Mini White", MinKelvin: 2700, MaxKelvin: 2700},
+	68: {Name: "LIFX Candle", Color: true, MinKelvin: 1500, MaxKelvin: 9000},
+	81: {Name: "LIFX Candle White to Warm", MinKelvin: 2200, MaxKelvin: 6500},
+	82: {Name: "LIFX Filament Clear", MinKelvin: 2100, MaxKelvin: 2100},
+	85: {Name: "LIFX Filament Amber", MinKelvin: 2000, MaxKelvin: 2000},
+	87: {Name: "LIFX Mini White", MinKelvin: 2700, MaxKelvin: 2700},
+	88: {Name: "LIFX Mini White", MinKelvin: 2700, MaxKelvin: 2700},
+	90: {Name: "LIFX Clean", Color: true, MinKelvin: 1500, MaxKelvin: 9000},
+	91: {Name: "LIFX Color", Color: true, MinKelvin: 1500, MaxKelvin: 9000},
+	92: {Name: "LIFX Color", Color: true, MinKelvin: 1500, MaxKelvin: 9000},
+	94: {Name: "LIFX BR30", Color: true, MinKelvin: 1500, MaxKelvin: 9000},
+	96: {Name: "LIFX Candle White to Warm", MinKelvin: 2200, MaxKelvin: 6500},
+	97: {Name: "LIFX A19", Color: true, MinKelvin: 1500, MaxKelvin: 9000},
+	98: {Name: "LIFX BR30", Color: true, MinKelvin: 1500, MaxKelvin: 9000},
+	99: {Name: "LIFX Clean", Color: true, MinKelvin: 1500, MaxKelvin: 9000},
+}
+
+// productForID returns the Product for a given ID.
+// Unknown products are assumed to be full-color bulbs.
+func productForID(id int) Product {
+	p, ok := productsByID[id]
+	if !ok {
+		p = Product{
+			Name:      fmt.Sprintf("unknown Lifx product %v", id),
+			Color:     true,
+			MinKelvin: MinKelvin,
+			MaxKelvin: MaxKelvin,
+		}
+	}
+	p.ID = id
+	return p
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+package lifx
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// acknowledgementTimeout is how long to wait for bulbs to acknowledge a message sent together, before confirming with them individually.
+const acknowledgementTimeout = 500 * time.Millisecond
+
+// sendTogether sends a message to several bulbs from one socket, one packet straight after another, so that they all change in the same instant.
+// It returns the bulbs that did not acknowledge the message.
+//
+// The Lifx LAN protocol has no way to address a group of bulbs in one packet:
+// a Tagged packet is handled by every bulb on the network, whatever group or location it is in.
+func sendTogether(ctx context.Context, bulbs []*bulb, message interface{}) ([]*bulb, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return bulbs, fmt.Errorf("could not listen on UDP: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(acknowledgementTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	pending := map[uint64]*bulb{}
+	for _, b := range bulbs {
+		if _, err := conn.WriteTo(b.packet(message, false, true), b.addr); err != nil {
+			return bulbs, fmt.Errorf("could not send packet: %w", err)
+		}
+		pending[b.id] = b
+	}
+
+	for len(pending) > 0 {
+		buf := make([]byte, 256)
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// Once the deadline passes, the remaining bulbs are confirmed individually.
+			break
+		}
+		if n < headerLength {
+			continue
+		}
+
+		hdr := &header{}
+		hdr.FromBytes(buf[0:headerLength])
+		if _, ok := messageForType(hdr.Type).(*acknowledgement); ok && hdr.Source == source {
+			delete(pending, hdr.Target)
+		}
+	}
+
+	var missing []*bulb
+	for _, b := range bulbs {
+		if _, ok := pending[b.id]; ok {
+			missing = append(missing, b)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,222 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+// Package mqtt connects to an MQTT broker as a catbus.Client, with the options that catbus.NewClient does not have.
+package mqtt
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"go.eth.moe/catbus"
+	"go.eth.moe/logger"
+)
+
+type (
+	// ClientOptions are catbus.ClientOptions, and how to connect to a secured broker.
+	ClientOptions struct {
+		ConnectHandler    func(catbus.Client)
+		DisconnectHandler func(catbus.Client, error)
+
+		// ClientID is empty for the broker to choose one.
+		ClientID string
+		// KeepAlive is how often the connection is checked, or 0 for paho's default.
+		KeepAlive time.Duration
+
+		Username string
+		Password string
+
+		// TLS is nil for paho's default, which verifies ssl:// and tls:// brokers against the system's CAs.
+		TLS *tls.Config
+
+		// Wills are published by the broker if the client goes without disconnecting, e.g. because it crashed.
+		Wills []Will
+	}
+
+	// Will is a retained message the broker publishes when the connection is lost, which the client undoes each time it connects.
+	Will struct {
+		Topic string
+		// Lost is published by the broker when the connection is lost, and Connected by the client when it connects.
+		Lost, Connected string
+	}
+
+	client struct {
+		paho paho.Client
+
+		// willHolders are connections that only hold the Wills after the first, as MQTT has one will per connection.
+		// They are connected and disconnected with the main connection, so that their Wills are published when it is lost.
+		willHolders   []willHolder
+		willHoldersMu sync.Mutex
+	}
+
+	willHolder struct {
+		paho paho.Client
+		will Will
+	}
+)
+
+// qos is "at least once" for every message, as all of catbus-lifx's payloads are idempotent.
+const qos = 1
+
+// lostTimeout is how long a will holder tries to publish its Lost payload when the main connection is lost.
+const lostTimeout = time.Second
+
+// NewClient returns a catbus.Client for the broker at a URI, e.g. "tcp://localhost:1883" or "ssl://broker:8883".
+// It reconnects whenever the connection is lost, and publishes each Will's Connected payload, then calls ConnectHandler, each time it connects.
+func NewClient(uri string, opts ClientOptions) catbus.Client {
+	c := &client{}
+
+	var will *Will
+	if len(opts.Wills) > 0 {
+		will = &opts.Wills[0]
+	}
+	o := pahoOptions(uri, opts.ClientID, opts, will)
+	o.SetOnConnectHandler(func(p paho.Client) {
+		if will != nil {
+			publishConnected(p, *will)
+		}
+		c.connectWillHolders()
+		if opts.ConnectHandler != nil {
+			opts.ConnectHandler(c)
+		}
+	})
+	o.SetConnectionLostHandler(func(_ paho.Client, err error) {
+		c.disconnectWillHolders()
+		if opts.DisconnectHandler != nil {
+			opts.DisconnectHandler(c, err)
+		}
+	})
+	c.paho = paho.NewClient(o)
+
+	for i := 1; i < len(opts.Wills); i++ {
+		will := opts.Wills[i]
+		// Brokers disconnect a client when another connects with the same ID, so each holder needs its own.
+		id := opts.ClientID
+		if id != "" {
+			id = fmt.Sprintf("%v-will-%d", id, i)
+		}
+		o := pahoOptions(uri, id, opts, &will)
+		o.SetOnConnectHandler(func(p paho.Client) {
+			publishConnected(p, will)
+		})
+		c.willHolders = append(c.willHolders, willHolder{paho.NewClient(o), will})
+	}
+	return c
+}
+
+func pahoOptions(uri, clientID string, opts ClientOptions, will *Will) *paho.ClientOptions {
+	o := paho.NewClientOptions()
+	o.AddBroker(uri)
+	o.SetClientID(clientID)
+	o.SetAutoReconnect(true)
+	if opts.Username != "" {
+		o.SetUsername(opts.Username)
+		o.SetPassword(opts.Password)
+	}
+	if opts.TLS != nil {
+		o.SetTLSConfig(opts.TLS)
+	}
+	if opts.KeepAlive > 0 {
+		o.SetKeepAlive(opts.KeepAlive)
+	}
+	if will != nil {
+		o.SetWill(will.Topic, will.Lost, qos, true)
+	}
+	return o
+}
+
+// publishConnected undoes a Will, which the broker may have published while the client was disconnected.
+func publishConnected(p paho.Client, will Will) {
+	if err := wait(p.Publish(will.Topic, qos, true, will.Connected)); err != nil {
+		log := logger.Background()
+		log.AddField("topic", will.Topic)
+		log.WithError(err).Error("could not publish connected payload of will")
+	}
+}
+
+// connectWillHolders connects the will holders that are not connected, each time the main connection connects.
+func (c *client) connectWillHolders() {
+	c.willHoldersMu.Lock()
+	defer c.willHoldersMu.Unlock()
+	c.connectWillHoldersLocked()
+}
+
+func (c *client) connectWillHoldersLocked() {
+	for _, h := range c.willHolders {
+		if h.paho.IsConnected() {
+			continue
+		}
+		if err := wait(h.paho.Connect()); err != nil {
+			log := logger.Background()
+			log.AddField("topic", h.will.Topic)
+			log.WithError(err).Error("could not connect to hold will")
+		}
+	}
+}
+
+// disconnectWillHolders publishes the Lost payload of each will holder and disconnects it, when the main connection is lost.
+// The broker only publishes a will when a connection goes without disconnecting, so each holder publishes its own first.
+func (c *client) disconnectWillHolders() {
+	c.willHoldersMu.Lock()
+	defer c.willHoldersMu.Unlock()
+	for _, h := range c.willHolders {
+		// A holder whose own connection is down has had its Will published by the broker already.
+		if h.paho.IsConnectionOpen() {
+			if err := waitTimeout(h.paho.Publish(h.will.Topic, qos, true, h.will.Lost), lostTimeout); err != nil {
+				log := logger.Background()
+				log.AddField("topic", h.will.Topic)
+				log.WithError(err).Error("could not publish lost payload of will")
+			}
+		}
+		h.paho.Disconnect(0)
+	}
+
+	// The main connection may have come back while the holders were disconnecting, after its connect handler saw them still connected.
+	if c.paho.IsConnectionOpen() {
+		c.connectWillHoldersLocked()
+	}
+}
+
+// Connect connects to the broker, and then blocks while the client reconnects whenever the connection is lost, as catbus.Client does.
+// The will holders are connected by the main connection's connect handler.
+func (c *client) Connect() error {
+	if err := wait(c.paho.Connect()); err != nil {
+		return err
+	}
+	select {}
+}
+
+func (c *client) Publish(topic string, retention catbus.Retention, payload string) error {
+	return wait(c.paho.Publish(topic, qos, bool(retention), payload))
+}
+
+func (c *client) Subscribe(topic string, f catbus.MessageHandler) error {
+	return wait(c.paho.Subscribe(topic, qos, func(_ paho.Client, m paho.Message) {
+		f(c, catbus.Message{
+			Topic:    m.Topic(),
+			Payload:  string(m.Payload()),
+			Retained: m.Retained(),
+		})
+	}))
+}
+
+func (c *client) Unsubscribe(topic string) error {
+	return wait(c.paho.Unsubscribe(topic))
+}
+
+func wait(t paho.Token) error {
+	t.Wait()
+	return t.Error()
+}
+
+// waitTimeout is wait, but gives up after a timeout, e.g. for a connection that may be going.
+func waitTimeout(t paho.Token, timeout time.Duration) error {
+	if !t.WaitTimeout(timeout) {
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+	return t.Error()
+}
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2020 Ethel Morgan
+//
+// SPDX-License-Identifier: MIT
+
+// Package solar calculates sunrise and sunset offline, using the sunrise equation.
+//
+// See https://en.wikipedia.org/wiki/Sunrise_equation.
+package solar
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// j2000 is the Julian date of 2000-01-01 12:00 UTC.
+	j2000 = 2451545.0
+	// unixEpoch is the Julian date of 1970-01-01 00:00 UTC.
+	unixEpoch = 2440587.5
+
+	// obliquity is the tilt of the Earth's axis, in degrees.
+	obliquity = 23.4397
+	// horizon is the altitude of the sun's center at sunrise & sunset, in degrees, allowing for refraction and the sun's radius.
+	horizon = -0.833
+)
+
+// Times returns the sunrise and sunset on the calendar day of t, in t's location, at a latitude and longitude in degrees.
+// Latitude is positive north, and longitude is positive east.
+// If the sun does not rise or does not set that day, ok is false.
+func Times(t time.Time, latitude, longitude float64) (sunrise, sunset time.Time, ok bool) {
+	year, month, day := t.Date()
+	n := math.Round(time.Date(year, month, day, 12, 0, 0, 0, time.UTC).Sub(time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)).Hours() / 24)
+
+	// meanNoon is the mean solar noon, in days since J2000.
+	meanNoon := n - longitude/360
+
+	meanAnomaly := math.Mod(357.5291+0.98560028*meanNoon, 360)
+	center := 1.9148*sin(meanAnomaly) + 0.0200*sin(2*meanAnomaly) + 0.0003*sin(3*meanAnomaly)
+	eclipticLongitude := math.Mod(meanAnomaly+center+180+102.9372, 360)
+
+	transit := j2000 + meanNoon + 0.0053*sin(meanAnomaly) - 0.0069*sin(2*eclipticLongitude)
+
+	sinDeclination := sin(eclipticLongitude) * sin(obliquity)
+	cosDeclination := math.Cos(math.Asin(sinDeclination))
+
+	cosHourAngle := (sin(horizon) - sin(latitude)*sinDeclination) / (cos(latitude) * cosDeclination)
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+
+	sunrise = fromJulian(transit - hourAngle/360).In(t.Location())
+	sunset = fromJulian(transit + hourAngle/360).In(t.Location())
+	return sunrise, sunset, true
+}
+
+func fromJulian(j float64) time.Time {
+	seconds := (j - unixEpoch) * 24 * 60 * 60
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+func sin(degrees float64) float64 {
+	return math.Sin(degrees * math.Pi / 180)
+}
+func cos(degrees float64) float64 {
+	return math.Cos(degrees * math.Pi / 180)
+}